@@ -19,13 +19,32 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"strconv"
 )
 
 type config struct {
+	// Server and Port point the bot at a different irc server, such as a
+	// local one for testing. They default to twitch irc.
+	Server string
+	Port   int
+	// DisableTLS connects without encryption.
+	DisableTLS bool
+	// Storage is the database backend: ql (the default), sqlite or memory.
+	// Database is the path to the database file.
+	Storage  string
+	Database string
+
+	// TwitchClientID and TwitchAPIToken are used for twitch api requests
+	// such as follower lookups.
+	TwitchClientID string
+	TwitchAPIToken string
+
 	GistOAuth     string
 	TwitchUser    string
 	TwitchOAuth   string
 	Ignore        []string
+	Owners        []string
 	Channels      []string
 	IsMod         bool
 	CaseSensitive bool
@@ -47,3 +66,22 @@ func loadConfig() (conf *config, err error) {
 
 	return
 }
+
+// address returns the host:port of the irc server in the config, filling in
+// twitch's server and the default port for the TLS setting.
+func (conf *config) address() string {
+	host := conf.Server
+	if len(host) == 0 {
+		host = "irc.chat.twitch.tv"
+	}
+
+	port := conf.Port
+	if port == 0 {
+		port = 6697
+		if conf.DisableTLS {
+			port = 6667
+		}
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
@@ -16,23 +16,93 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"github.com/Francesco149/shigebot/shige"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// exit codes
+const (
+	exitOK = iota
+	// the config couldn't be loaded or the bot failed to start
+	exitInitFailed
+	// pending work was abandoned or the database couldn't be closed cleanly
+	exitShutdownFailed
 )
 
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	dryRun := flag.Bool("dry-run", false, "check that the database can be "+
+		"upgraded to the current schema without saving anything, then exit")
+	flag.Parse()
+
 	conf, err := loadConfig()
 	if err != nil {
-		return
+		return exitInitFailed
 	}
 
-	bot, err := shige.Init(conf.TwitchUser, conf.TwitchOAuth, conf.GistOAuth,
-		conf.Channels, conf.IsMod, conf.CaseSensitive)
+	logger := log.New(os.Stdout, "", 0)
+
+	if *dryRun {
+		err = shige.DryRunMigrations(conf.Storage, conf.Database, logger)
+		if err != nil {
+			fmt.Println("Database upgrade would fail", err)
+			return exitInitFailed
+		}
+		return exitOK
+	}
+
+	storage, err := shige.OpenStorage(conf.Storage, conf.Database, logger)
+	if err != nil {
+		fmt.Println("Failed to open storage", err)
+		return exitInitFailed
+	}
+
+	bot, err := shige.New(
+		shige.WithLogger(logger),
+		shige.WithStorage(storage),
+		shige.WithServer(conf.address(), !conf.DisableTLS),
+		shige.WithCredentials(conf.TwitchUser, conf.TwitchOAuth),
+		shige.WithTwitchAPI(conf.TwitchClientID, conf.TwitchAPIToken),
+		shige.WithGistOAuth(conf.GistOAuth),
+		shige.WithChannels(conf.Channels...),
+		shige.WithMod(conf.IsMod),
+		shige.WithCaseSensitive(conf.CaseSensitive),
+	)
 	if err != nil {
 		fmt.Println("Failed to initialize bot", err)
-		return
+		storage.Close()
+		return exitInitFailed
 	}
 
 	bot.Ignore(conf.Ignore...)
-	bot.Run()
+	bot.SetOwners(conf.Owners...)
+
+	// ctrl+c and service managers stopping the bot shut it down cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	defer stop()
+
+	// retries until twitch can be reached or the bot is stopped
+	err = bot.Connect(ctx)
+	if err != nil {
+		fmt.Println("Failed to connect", err)
+		return exitInitFailed
+	}
+
+	err = bot.Run(ctx)
+	if err != nil {
+		fmt.Println("Failed to shut down cleanly", err)
+		return exitShutdownFailed
+	}
+
+	return exitOK
 }
@@ -0,0 +1,110 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// how many entries !modlog shows by default and at most
+	defaultModlogEntries = 3
+	maxModlogEntries     = 10
+	// how many entries are published with the command list
+	modlogExportEntries = 500
+)
+
+// An AuditEntry is a moderation action in the channel's audit log.
+type AuditEntry struct {
+	Time time.Time
+	// Who is the nick of the moderator, or the bot's own nick for automated
+	// actions.
+	Who string
+	// Action is what was done, such as the command that was used.
+	Action string
+	// Target is what the action was done to, such as a command name or a
+	// user.
+	Target string
+	// Before and After are the values before and after the change. Either
+	// can be empty, for example when a command is added or removed.
+	Before, After string
+}
+
+func (e *AuditEntry) String() string {
+	res := fmt.Sprintf("%s %s %s %s", e.Time.UTC().Format("2006-01-02 15:04"),
+		e.Who, e.Action, e.Target)
+	switch {
+	case len(e.Before) != 0 && len(e.After) != 0:
+		res += fmt.Sprintf(": \"%s\" -> \"%s\"", e.Before, e.After)
+	case len(e.Before) != 0:
+		res += fmt.Sprintf(": was \"%s\"", e.Before)
+	case len(e.After) != 0:
+		res += fmt.Sprintf(": \"%s\"", e.After)
+	}
+	return res
+}
+
+// Audit records a moderation action in the channel's audit log. Failures are
+// logged rather than returned, since the action already happened.
+func (c *Channel) Audit(who, action, target, before, after string) {
+	e := &AuditEntry{
+		Time:   c.parent.now(),
+		Who:    who,
+		Action: action,
+		Target: target,
+		Before: before,
+		After:  after,
+	}
+
+	c.Println("Audit:", e)
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.AddAuditEntry(c.name, e)
+	})
+	if err != nil {
+		c.Println("Failed to save audit log entry:", err)
+	}
+}
+
+// auditBot records an automated action taken by the bot.
+func (c *Channel) auditBot(action, target, after string) {
+	c.Audit(strings.ToLower(c.parent.twitchUser), action, target, "", after)
+}
+
+// AuditLog returns the last n entries of the audit log, newest first, or
+// every entry if n is zero or less.
+func (c *Channel) AuditLog(n int) (entries []*AuditEntry, err error) {
+	err = c.parent.attemptQuery(func() (err error) {
+		entries, err = c.parent.db.AuditLog(c.name, n)
+		return
+	})
+	return
+}
+
+// AuditLogList returns the last entries of the audit log formatted as a
+// markdown list, oldest first.
+func (c *Channel) AuditLogList() (res string, err error) {
+	entries, err := c.AuditLog(modlogExportEntries)
+	if err != nil {
+		return
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		res += fmt.Sprintf("* %s\n", entries[i])
+	}
+	return
+}
@@ -0,0 +1,271 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A BlacklistKind is how a blacklist pattern is matched.
+type BlacklistKind string
+
+const (
+	// BlacklistWord matches the pattern as a whole word.
+	BlacklistWord BlacklistKind = "word"
+	// BlacklistPhrase matches the pattern anywhere in the message.
+	BlacklistPhrase BlacklistKind = "phrase"
+	// BlacklistRegex matches the pattern as a go regular expression.
+	BlacklistRegex BlacklistKind = "regex"
+)
+
+// A BlacklistAction is what happens to a message that matches the
+// blacklist.
+type BlacklistAction string
+
+const (
+	// BlacklistDelete deletes the message.
+	BlacklistDelete BlacklistAction = "delete"
+	// BlacklistTimeout times the user out.
+	BlacklistTimeout BlacklistAction = "timeout"
+	// BlacklistBan bans the user.
+	BlacklistBan BlacklistAction = "ban"
+)
+
+// A BlacklistEntry is a word, phrase or regular expression that isn't
+// allowed in a channel. Words and phrases are case insensitive and also match
+// leetspeak and look-alike characters, so "b4d" matches "bad".
+type BlacklistEntry struct {
+	Pattern string
+	Kind    BlacklistKind
+	Action  BlacklistAction
+	// Timeout is how long users are timed out for when Action is
+	// BlacklistTimeout.
+	Timeout time.Duration
+
+	re *regexp.Regexp
+}
+
+// compiles the entry's pattern. Words and phrases are compiled to match
+// normalized text.
+func (e *BlacklistEntry) compile() (err error) {
+	switch e.Kind {
+	case BlacklistRegex:
+		e.re, err = regexp.Compile(e.Pattern)
+	case BlacklistWord:
+		e.re, err = regexp.Compile(`(?:^|[^\pL\pN])` +
+			regexp.QuoteMeta(normalizeText(e.Pattern, true)) +
+			`(?:$|[^\pL\pN])`)
+	case BlacklistPhrase:
+		e.re, err = regexp.Compile(
+			regexp.QuoteMeta(normalizeText(e.Pattern, true)))
+	default:
+		err = fmt.Errorf("Unknown blacklist type %s.", e.Kind)
+	}
+	return
+}
+
+// Matches returns whether msg contains the entry. The message is normalized
+// both with and without leetspeak, since punctuation such as "bad!" would
+// otherwise turn into letters. Regular expressions are also tried on the
+// message as is.
+func (e *BlacklistEntry) Matches(msg string) bool {
+	if e.re == nil {
+		return false
+	}
+	if e.Kind == BlacklistRegex && e.re.MatchString(msg) {
+		return true
+	}
+	return e.re.MatchString(normalizeText(msg, false)) ||
+		e.re.MatchString(normalizeText(msg, true))
+}
+
+// String describes the entry without its pattern, so it can be shown in chat
+// without repeating what's blacklisted.
+func (e *BlacklistEntry) String() string {
+	action := string(e.Action)
+	if e.Action == BlacklistTimeout {
+		action += fmt.Sprintf(" %ds", int(e.Timeout/time.Second))
+	}
+	return fmt.Sprintf("%s (%s)", e.Kind, action)
+}
+
+// leetspeak maps digits and symbols to the letter they imitate.
+var leetspeak = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b',
+	'@': 'a', '$': 's', '!': 'i', '|': 'l', '+': 't',
+}
+
+// confusables maps characters that look like latin letters to the letter
+// they imitate.
+var confusables = map[rune]rune{
+	// cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i', 'ј': 'j',
+	'ѕ': 's',
+	// greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o',
+	'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+}
+
+// normalizeText lower cases text and replaces look-alike and fullwidth
+// characters with plain latin letters, as well as leetspeak if leet is true.
+// Combining marks such as accents added to dodge filters are dropped.
+func normalizeText(text string, leet bool) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(text) {
+		// fullwidth forms of ascii characters
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r = unicode.ToLower(r - 0xFEE0)
+		}
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if c, ok := confusables[r]; ok {
+			r = c
+		} else if c, ok := leetspeak[r]; ok && leet {
+			r = c
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Blacklist returns a copy of every blacklist entry, sorted by pattern.
+func (c *Channel) Blacklist() (res []BlacklistEntry) {
+	c.parent.w.Await(func() {
+		for _, e := range c.blacklist {
+			res = append(res, *e)
+		}
+	})
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Pattern < res[j].Pattern
+	})
+	return
+}
+
+// AddBlacklist adds or replaces a blacklist entry.
+func (c *Channel) AddBlacklist(e BlacklistEntry) error {
+	if len(strings.TrimSpace(e.Pattern)) == 0 {
+		return fmt.Errorf("Blacklist patterns can't be empty.")
+	}
+
+	switch e.Action {
+	case BlacklistDelete, BlacklistBan:
+	case BlacklistTimeout:
+		if e.Timeout < time.Second {
+			return fmt.Errorf("Timeouts must last at least a second.")
+		}
+	default:
+		return fmt.Errorf("Unknown blacklist action %s.", e.Action)
+	}
+
+	if err := e.compile(); err != nil {
+		return fmt.Errorf("Invalid blacklist pattern: %v", err)
+	}
+
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.AddBlacklist(c.name, &e)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.parent.w.Await(func() { c.blacklist[e.Pattern] = &e })
+	c.Println("Blacklisted", e.Kind, e.Pattern, e.String())
+	return nil
+}
+
+// RemoveBlacklist removes the entry with the given pattern.
+func (c *Channel) RemoveBlacklist(pattern string) error {
+	exists := false
+	c.parent.w.Await(func() { _, exists = c.blacklist[pattern] })
+	if !exists {
+		return fmt.Errorf("%s is not blacklisted.", pattern)
+	}
+
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.RemoveBlacklist(c.name, pattern)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.parent.w.Await(func() { delete(c.blacklist, pattern) })
+	c.Println("Removed", pattern, "from the blacklist")
+	return nil
+}
+
+// BlacklistList returns the blacklist entries, patterns included, formatted
+// as a markdown list. It shouldn't be shown in chat.
+func (c *Channel) BlacklistList() (res string) {
+	for _, e := range c.Blacklist() {
+		res += fmt.Sprintf("* `%s` %s\n", e.Pattern, e.String())
+	}
+	return
+}
+
+// checkBlacklist returns the first entry, by pattern, that msg matches, or
+// nil. Moderators are never checked, and nothing is when the bot can't
+// moderate the channel.
+func (c *Channel) checkBlacklist(msg string, user *UserInfo) *BlacklistEntry {
+	if !c.parent.isModIn(c.name) || c.HasPermission(user, PermModerator) {
+		return nil
+	}
+
+	entries := c.Blacklist()
+	for i := range entries {
+		if entries[i].Matches(msg) {
+			return &entries[i]
+		}
+	}
+	return nil
+}
+
+// enforceBlacklist runs the action of a blacklist entry on the user that
+// sent a matching message. messageID is the id tag of the message, which is
+// needed to delete it.
+func (c *Channel) enforceBlacklist(e *BlacklistEntry, user *UserInfo,
+	messageID string) {
+
+	c.Println("Blacklist", e.Kind, e.Pattern, "caught", user.Nick)
+	action := string(e.Action)
+	if e.Action == BlacklistTimeout {
+		action = fmt.Sprintf("%v timeout", e.Timeout)
+	}
+	c.auditBot(action, user.Nick, "blacklisted "+e.String())
+
+	switch e.Action {
+	case BlacklistBan:
+		c.ModPrivmsgf("/ban %s blacklisted %s", user.Nick, e.Kind)
+
+	case BlacklistTimeout:
+		c.ModPrivmsgf("/timeout %s %d blacklisted %s", user.Nick,
+			int(e.Timeout/time.Second), e.Kind)
+
+	default:
+		if len(messageID) != 0 {
+			c.ModPrivmsgf("/delete %s", messageID)
+			return
+		}
+		// without the id, purging is the closest thing to deleting
+		c.ModPrivmsgf("/timeout %s 1 blacklisted %s", user.Nick, e.Kind)
+	}
+}
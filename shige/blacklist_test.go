@@ -0,0 +1,102 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import "testing"
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		text string
+		leet bool
+		want string
+	}{
+		{"Hello", false, "hello"},
+		{"b4d w0rd", false, "b4d w0rd"},
+		{"b4d w0rd", true, "bad word"},
+		{"bad!", false, "bad!"},
+		{"bad!", true, "badi"},
+		{"$h1t", true, "shit"},
+		{"Ｂａｄ", false, "bad"},
+		{"ｂ４ｄ", true, "bad"},
+		{"b\u0430d w\u043erd", false, "bad word"}, // cyrillic
+		{"w\u03bfrd", false, "word"},              // greek
+		{"ba\u0301d", false, "bad"},               // combining accent
+		{"café", false, "café"},
+	}
+
+	for _, test := range tests {
+		got := normalizeText(test.text, test.leet)
+		if got != test.want {
+			t.Errorf("normalizeText(%q, %v) = %q, want %q", test.text,
+				test.leet, got, test.want)
+		}
+	}
+}
+
+func TestBlacklistMatches(t *testing.T) {
+	tests := []struct {
+		kind    BlacklistKind
+		pattern string
+		msg     string
+		want    bool
+	}{
+		{BlacklistWord, "bad", "that is bad", true},
+		{BlacklistWord, "bad", "BAD", true},
+		{BlacklistWord, "bad", "that's bad!", true},
+		{BlacklistWord, "bad", "b4d", true},
+		{BlacklistWord, "bad", "ｂａｄ idea", true},
+		{BlacklistWord, "bad", "b\u0430d", true},
+		{BlacklistWord, "bad", "ba\u0301d", true},
+		{BlacklistWord, "bad", "badge", false},
+		{BlacklistWord, "bad", "sinbad", false},
+		{BlacklistWord, "bad", "good", false},
+		{BlacklistPhrase, "bad", "badge", true},
+		{BlacklistPhrase, "bad word", "such a b4d w0rd", true},
+		{BlacklistPhrase, "bad word", "bad  word", false},
+		{BlacklistRegex, `^buy \w+ now$`, "buy followers now", true},
+		{BlacklistRegex, `^buy \w+ now$`, "please buy followers now", false},
+		{BlacklistRegex, `(?i)spam`, "SPAM", true},
+	}
+
+	for _, test := range tests {
+		e := &BlacklistEntry{Pattern: test.pattern, Kind: test.kind}
+		if err := e.compile(); err != nil {
+			t.Errorf("compile %s %q: %v", test.kind, test.pattern, err)
+			continue
+		}
+		if got := e.Matches(test.msg); got != test.want {
+			t.Errorf("%s %q matches %q = %v, want %v", test.kind,
+				test.pattern, test.msg, got, test.want)
+		}
+	}
+}
+
+func TestBlacklistCompileErrors(t *testing.T) {
+	tests := []BlacklistEntry{
+		{Pattern: "(", Kind: BlacklistRegex},
+		{Pattern: "bad", Kind: "glob"},
+	}
+
+	for _, e := range tests {
+		if err := e.compile(); err == nil {
+			t.Errorf("compile %s %q succeeded, want an error", e.Kind,
+				e.Pattern)
+		}
+		if e.Matches(e.Pattern) {
+			t.Errorf("broken %s entry %q matched", e.Kind, e.Pattern)
+		}
+	}
+}
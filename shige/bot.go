@@ -17,13 +17,27 @@
 package shige
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/thoj/go-ircevent"
+	"log"
+	"net"
+	"net/http"
 	"strings"
+	"time"
 )
 
 const BotName = "Shigebot 1.2.2"
 
+const (
+	// DefaultServer is the address of twitch irc's TLS endpoint.
+	DefaultServer = "irc.chat.twitch.tv:6697"
+	// DefaultPlaintextServer is the address of twitch irc's unencrypted
+	// endpoint.
+	DefaultPlaintextServer = "irc.chat.twitch.tv:6667"
+)
+
 // A Bot is an instance of Shigebot connected to multiple channels on twitch
 // on a single twitch account.
 type Bot struct {
@@ -33,21 +47,51 @@ type Bot struct {
 	// handlers) or false otherwise.
 	OnPrivmsg func(*irc.Event) bool
 
-	// The documentation for all the built-in commands. This is already set
-	// by default, but it can be modified when there is a need to customize the
-	// built-in commands or how they show up in the gist.
-	BuiltinCommandsInfo string
+	// If not nil, this function will be called whenever the connection to
+	// twitch irc changes state. err is the reason for the change, such as
+	// the error that caused a disconnection, and can be nil.
+	OnStateChange func(state ConnState, err error)
 
 	irc           *irc.Connection
 	w             *Worker
-	db            dbManager
+	counterWorker *Worker // serializes counter changes, see updateCounter
+	db            Storage
 	isMod         bool
 	caseSensitive bool
 	gistOAuth     string
 	channels      map[string]*Channel
-	commands      map[string]func(*CommandData)
-	rateLimiter   *rateLimiter
+	commands      map[string]Command
+	owners        map[string]bool
+	stopTimers    chan bool
+	queue         *messageQueue
 	ignore        map[string]bool
+
+	state             ConnState
+	reconnectAttempts int
+	quitting          bool
+	quit              chan bool // closed when quitting is set
+	pending           *pendingWork
+
+	// set by options
+	twitchUser      string
+	twitchOAuth     string
+	clientID        string
+	apiToken        string
+	channelList     []string
+	address         string
+	useTLS          bool
+	log             *log.Logger
+	dbFile          string
+	http            *http.Client
+	publisher       Publisher
+	clock           Clock
+	messageLimit    int
+	ratePeriod      time.Duration
+	queueLimit      int
+	dropPolicy      DropPolicy
+	duplicates      DuplicateStrategy
+	maxChunks       int
+	shutdownTimeout time.Duration
 }
 
 // Irc returns a pointer to the irc connection object used by the bot.
@@ -64,19 +108,30 @@ func (b Bot) Channel(channel string) *Channel {
 }
 
 // Join makes the bot join channel and load any commands that might have been
-// previously saved for that channel.
-func (b *Bot) Join(channel string) {
-	fmt.Println("> Joining", channel)
-	ch := newChannel(b, channel)
+// previously saved for that channel. The channel isn't joined if its data
+// can't be loaded.
+func (b *Bot) Join(channel string) error {
+	if !b.pending.add() {
+		return nil
+	}
+	defer b.pending.done()
+
+	b.log.Println("> Joining", channel)
+	ch, err := newChannel(b, channel)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %v", channel, err)
+	}
+
 	b.w.Await(func() {
 		b.irc.Join(channel)
 		b.channels[channel] = ch
 	})
+	return nil
 }
 
 // Part makes the bot leave channel.
 func (b Bot) Part(channel string) {
-	fmt.Println("> Leaving", channel)
+	b.log.Println("> Leaving", channel)
 	b.irc.Part(channel)
 	b.w.Await(func() { delete(b.channels, channel) })
 }
@@ -86,56 +141,153 @@ func (b Bot) Part(channel string) {
 // is joined (channel names must include the # prefix).
 // The isMod flag specifies whether the bot's account is a moderator in the
 // channels it will join. Running in non-moderator mode will result in a lower
-// message rate limit as well as randomization of each message by appending
-// a random number to bypass twitch spam prevention.
+// message rate limit, and messages that twitch would reject as duplicates
+// are altered invisibly (see DuplicateStrategy).
 // caseSensitive makes text commands case sensitive if true.
 // gistOAuth is the github oauth token that will be used to upload the command
 // list.
+// The connection is encrypted with TLS (see DefaultServer).
 // Returns a pointer to the Bot instance and an error if anything goes wrong.
+// Init is kept for compatibility, new code should use New and Connect.
 func Init(twitchUser, twitchOauth, gistOAuth string, channelList []string,
 	isMod, caseSensitive bool) (b *Bot, err error) {
 
-	fmt.Printf("> %s\n", BotName)
+	return InitServer(DefaultServer, true, twitchUser, twitchOauth,
+		gistOAuth, channelList, isMod, caseSensitive)
+}
+
+// InitServer is like Init but connects to the irc server at address
+// (host:port) instead of twitch's, using TLS if useTLS is true. This is
+// useful to run the bot against a local irc server for testing.
+func InitServer(address string, useTLS bool, twitchUser, twitchOauth,
+	gistOAuth string, channelList []string, isMod, caseSensitive bool) (
+	b *Bot, err error) {
+
+	b, err = New(
+		WithServer(address, useTLS),
+		WithCredentials(twitchUser, twitchOauth),
+		WithGistOAuth(gistOAuth),
+		WithChannels(channelList...),
+		WithMod(isMod),
+		WithCaseSensitive(caseSensitive),
+	)
+	if err != nil {
+		return
+	}
+
+	err = b.Connect(context.Background())
+	return
+}
+
+// New creates a bot configured by opts without connecting it. The database
+// is opened and the built-in commands are registered right away, so the bot
+// can be customized before calling Connect.
+func New(opts ...Option) (b *Bot, err error) {
 	b = &Bot{
-		isMod:         isMod,
-		caseSensitive: caseSensitive,
-		gistOAuth:     gistOAuth,
-		w:             NewWorker("shigebot", 500),
+		w:       NewWorker("shigebot", 500),
+		owners:  make(map[string]bool),
+		pending: &pendingWork{},
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.applyDefaults()
+
+	host, _, err := net.SplitHostPort(b.address)
+	if err != nil {
+		return
 	}
 
+	b.log.Printf("> %s\n", BotName)
+
 	// initialize everything
 	err = b.initDB()
 	if err != nil {
 		return
 	}
+
+	// registering commands goes through the worker
+	b.w.Start()
+	b.counterWorker = NewWorker("counters", 500)
+	b.counterWorker.Start()
 	b.initCommands()
-	b.initRateLimiter()
-	b.initIgnoreList(twitchUser)
+	b.initQueue()
+	b.initIgnoreList(b.twitchUser)
 
-	// connect to twitch irc
-	ircobj := irc.IRC(twitchUser, twitchUser)
-	ircobj.Password = twitchOauth
+	ircobj := irc.IRC(b.twitchUser, b.twitchUser)
+	ircobj.Password = b.twitchOAuth
+	ircobj.PingFreq = pingFrequency
+	ircobj.KeepAlive = pingFrequency
+	ircobj.Timeout = readTimeout
+	ircobj.UseTLS = b.useTLS
+	ircobj.TLSConfig = &tls.Config{ServerName: host}
 
 	b.irc = ircobj
 
-	err = ircobj.Connect("irc.twitch.tv:6667")
-	if err != nil {
-		return
-	}
+	b.w.Await(func() { b.channels = make(map[string]*Channel) })
+	b.quit = make(chan bool)
+	b.stopTimers = make(chan bool)
+	go b.runTimers(b.stopTimers)
+	go b.runQueue()
 
-	b.w.Start()
 	// irc callbacks
 	ircobj.AddCallback("001", func(e *irc.Event) {
-		ircobj.SendRaw("CAP REQ :twitch.tv/membership") // userlist & modesets
+		b.setState(StateConnected, nil)
+
+		// membership: userlist & modesets
+		// tags: badges, display names and user ids on every message
+		// commands: twitch specific messages such as CLEARCHAT and RECONNECT
+		ircobj.SendRaw("CAP REQ :twitch.tv/membership twitch.tv/tags " +
+			"twitch.tv/commands")
+
+		// after a reconnect, rejoin the channels we were in, keeping their
+		// state. otherwise join the initial channel list.
+		rejoin := make([]string, 0)
+		b.w.Await(func() {
+			for name := range b.channels {
+				rejoin = append(rejoin, name)
+			}
+		})
+
+		if len(rejoin) == 0 {
+			for _, channel := range b.channelList {
+				if err := b.Join(channel); err != nil {
+					b.log.Println(err)
+				}
+			}
+			return
+		}
 
-		// join all channels
-		b.w.Await(func() { b.channels = make(map[string]*Channel) })
-		for _, channel := range channelList {
-			b.Join(channel)
+		for _, channel := range rejoin {
+			b.log.Println("> Rejoining", channel)
+			ircobj.Join(channel)
+		}
+	})
+
+	// twitch asks clients to reconnect before restarting a server
+	ircobj.AddCallback("RECONNECT", func(e *irc.Event) {
+		b.log.Println("> Server requested a reconnect")
+		go ircobj.Disconnect()
+	})
+
+	// twitch sends the channel's id and chat settings after joining
+	ircobj.AddCallback("ROOMSTATE", func(e *irc.Event) {
+		id := e.Tags["room-id"]
+		if len(e.Arguments) == 0 || len(id) == 0 {
+			return
+		}
+		if c := b.Channel(e.Arguments[0]); c != nil {
+			c.setRoomID(id)
 		}
 	})
 
 	ircobj.AddCallback("PRIVMSG", func(event *irc.Event) {
+		// messages that arrive while shutting down are dropped
+		if !b.pending.add() {
+			return
+		}
+		defer b.pending.done()
+
 		if b.OnPrivmsg != nil && !b.OnPrivmsg(event) {
 			return
 		}
@@ -147,14 +299,37 @@ func Init(twitchUser, twitchOauth, gistOAuth string, channelList []string,
 
 		c := b.Channel(channelName)
 		c.Printf("%s: %s\n", nick, msg)
+		c.countLine()
+
+		// badges are available right away, unlike MODE messages which can
+		// take minutes to arrive after joining. They're only trusted for this
+		// message so a demoted mod loses access with their badge.
+		user := parseUserInfo(channelName, event)
+
+		// spam filters run before anything else so spam can't fire commands
+		if !b.Ignored(nick) {
+			filter := c.checkFilters(msg, user, event.Tags["emotes"])
+			if len(filter) != 0 {
+				c.punish(user, filter)
+				return
+			}
+
+			if e := c.checkBlacklist(msg, user); e != nil {
+				c.enforceBlacklist(e, user, event.Tags["id"])
+				return
+			}
+		}
 
 		// ignore empty messages
 		if len(msg) <= 1 {
 			return
 		}
 
-		// only handle commands
+		// anything that isn't a command can only fire keyword triggers
 		if msg[0] != '!' {
+			if !b.Ignored(nick) {
+				c.checkTriggers(msg, &CommandData{c, nil, nick, user})
+			}
 			return
 		}
 
@@ -172,28 +347,24 @@ func Init(twitchUser, twitchOauth, gistOAuth string, channelList []string,
 			return
 		}
 
-		builtinCommand := b.Command(cmd)
-
-		switch {
-		// global built-in commands
-		case builtinCommand != nil:
-			c.Println("Processing command", cmd, args)
-			builtinCommand(&CommandData{c, args, nick})
-
-		// simple text commands
-		// args are not used here.
-		case !b.Ignored(nick) && c.onCommand(cmd, nick):
-			break
+		// built-in commands take priority over the channel's commands
+		command := b.Command(cmd)
+		if command == nil {
+			command = c.lookupCommand(cmd)
+		}
 
-		// if OnCommand did not recognize the command, then it's definitely
-		// an invalid one
-		default:
+		// if neither recognized the command, then it's definitely an invalid
+		// one
+		if command == nil {
 			c.Println("Invalid command", cmd)
+			return
 		}
+
+		c.runCommand(command, &CommandData{c, args, nick, user})
 	})
 
 	ircobj.AddCallback("MODE", func(event *irc.Event) {
-		fmt.Println("MODE", event.Arguments)
+		b.log.Println("MODE", event.Arguments)
 
 		// we only want user modesets
 		if len(event.Arguments) != 3 {
@@ -226,10 +397,82 @@ func Init(twitchUser, twitchOauth, gistOAuth string, channelList []string,
 	return
 }
 
-// Run starts the bot, allowing it to start handling commands.
-func (b Bot) Run() {
-	b.irc.Loop()
-	fmt.Println("Waiting for worker to terminate")
-	b.w.Terminate()
-	return
+// Connect connects to the irc server. Channels are joined once the server
+// accepts the credentials. If the server can't be reached, for example
+// because twitch is down, Connect keeps retrying with the same backoff as
+// lost connections. Returns early with ctx's error if ctx is done before the
+// connection is established.
+func (b *Bot) Connect(ctx context.Context) error {
+	for {
+		err := b.dial(ctx)
+		if _, ok := err.(net.Error); !ok || ctx.Err() != nil {
+			return err
+		}
+
+		attempt := 0
+		b.w.Await(func() {
+			attempt = b.reconnectAttempts
+			b.reconnectAttempts++
+		})
+
+		delay := reconnectDelay(attempt)
+		b.setState(StateReconnecting, err)
+		b.log.Println("> Failed to connect, retrying in", delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			b.setState(StateDisconnected, ctx.Err())
+			return ctx.Err()
+		}
+	}
+}
+
+// dial makes a single attempt at connecting to the irc server.
+func (b *Bot) dial(ctx context.Context) error {
+	b.setState(StateConnecting, nil)
+	b.log.Println("> Connecting to", b.address, "tls:", b.useTLS)
+
+	done := make(chan error, 1)
+	go func() { done <- b.irc.Connect(b.address) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			b.setState(StateDisconnected, err)
+		}
+		return err
+
+	case <-ctx.Done():
+		// the irc library can't abort a dial, so clean up once it returns
+		go func() {
+			if <-done == nil {
+				b.irc.Disconnect()
+			}
+		}()
+		b.setState(StateDisconnected, ctx.Err())
+		return ctx.Err()
+	}
+}
+
+// Run starts the bot, allowing it to start handling commands. Lost
+// connections are automatically re-established until Quit is called or ctx
+// is done. The bot then parts every channel, waits up to the shutdown timeout
+// for pending commands, messages and uploads, disconnects and closes the
+// database. Returns ErrShutdownTimeout if pending work had to be abandoned.
+// The bot can't be reused after Run returns.
+func (b *Bot) Run(ctx context.Context) error {
+	supervised := make(chan bool)
+	go func() {
+		b.supervise()
+		close(supervised)
+	}()
+
+	select {
+	case <-supervised:
+	case <-ctx.Done():
+		b.log.Println("> Shutting down:", ctx.Err())
+	}
+
+	return b.shutdown(supervised)
 }
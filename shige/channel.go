@@ -17,6 +17,7 @@ package shige
 
 import (
 	"fmt"
+	"net/url"
 	"sort"
 	"time"
 )
@@ -25,49 +26,181 @@ import (
 type TextCommand struct {
 	// Text is the reply that the command will trigger.
 	Text string
-	// ModOnly is true when the command is reserved for mods.
-	ModOnly bool
-	// LastUsage is the time the command was last used
-	LastUsage time.Time
+	// Perm is the minimum role required to use the command.
+	Perm Permission
+	// Uses is the number of times the command has been used.
+	Uses int
+	// GlobalCooldown is the minimum time between two uses of the command.
+	// Zero means that the channel's default cooldown applies.
+	GlobalCooldown time.Duration
+	// UserCooldown is the minimum time between two uses of the command by
+	// the same user.
+	UserCooldown time.Duration
+	// ModsExempt is true when moderators ignore the cooldowns.
+	ModsExempt bool
+
+	name    string
+	aliases []string
+}
+
+func (t *TextCommand) Name() string            { return t.name }
+func (t *TextCommand) Aliases() []string       { return t.aliases }
+func (t *TextCommand) Permission() Permission  { return t.Perm }
+func (t *TextCommand) Cooldown() time.Duration { return t.GlobalCooldown }
+func (t *TextCommand) Help() string            { return t.Text }
+
+func (t *TextCommand) PerUserCooldown() time.Duration { return t.UserCooldown }
+func (t *TextCommand) ExemptsMods() bool              { return t.ModsExempt }
+
+// Run replies with the command's text, filling in any template variables.
+func (t *TextCommand) Run(c *CommandData) {
+	ch := c.Channel
+	count := ch.incrementUses(t.name)
+
+	tmpl, err := parseTemplate(t.Text)
+	if err != nil {
+		// commands saved before templates existed might not parse
+		ch.Println("Failed to parse template for", t.name, err)
+		ch.Privmsgf("%s", t.Text)
+		return
+	}
+
+	ch.Privmsgf("%s", tmpl.render(&templateContext{c, count}))
 }
 
 // A Channel is a single irc channel to which the bot is connected.
 type Channel struct {
-	commandCooldown  int32
-	name             string
-	mods             map[string]bool
-	commands         map[string]*TextCommand
-	parent           *Bot
-	builtinLastUsage map[string]time.Time
+	commandCooldown int32
+	name            string
+	roomID          string
+	mods            map[string]bool
+	commands        map[string]*TextCommand
+	aliases         map[string]string
+	counters        map[string]int
+	timers          map[string]*Timer
+	triggers        map[string]*Trigger
+	filters         map[string]*FilterRule
+	whitelist       map[string]bool
+	blacklist       map[string]*BlacklistEntry
+	lines           int
+	parent          *Bot
+	lastUsage       map[string]time.Time
+	userCooldowns   map[string]time.Time
+	followers       map[string]followerStatus
+	permits         map[string]time.Time
 }
 
 // I don't really need a map for mods but looking up names is less code.
 // chMods and chCommands need to be thread safe as the irc library fires events
 // asynchronously as goroutines.
 
-func newChannel(parent *Bot, name string) *Channel {
+func newChannel(parent *Bot, name string) (*Channel, error) {
+	db := parent.db
+	cooldown, err := db.ChannelCooldown(name)
+	if err != nil {
+		return nil, err
+	}
+	commands, err := db.Commands(name)
+	if err != nil {
+		return nil, err
+	}
+	aliases, err := db.Aliases(name)
+	if err != nil {
+		return nil, err
+	}
+	counters, err := db.Counters(name)
+	if err != nil {
+		return nil, err
+	}
+	timers, err := db.Timers(name)
+	if err != nil {
+		return nil, err
+	}
+	triggers, err := db.Triggers(name)
+	if err != nil {
+		return nil, err
+	}
+	filters, err := db.Filters(name)
+	if err != nil {
+		return nil, err
+	}
+	domains, err := db.Whitelist(name)
+	if err != nil {
+		return nil, err
+	}
+	blacklist, err := db.Blacklist(name)
+	if err != nil {
+		return nil, err
+	}
+	gistUrl, err := db.Gist(name)
+	if err != nil {
+		return nil, err
+	}
+
 	c := &Channel{
-		0,
+		cooldown,
 		name,
+		"",
+		make(map[string]bool),
+		commands,
+		aliases,
+		counters,
+		timers,
+		triggers,
+		filters,
 		make(map[string]bool),
-		parent.db.getCommands(name),
+		make(map[string]*BlacklistEntry),
+		0,
 		parent,
 		make(map[string]time.Time),
+		make(map[string]time.Time),
+		make(map[string]followerStatus),
+		make(map[string]time.Time),
+	}
+
+	for name, command := range c.commands {
+		command.name = name
+	}
+
+	for _, domain := range domains {
+		c.whitelist[domain] = true
+	}
+
+	// timers first fire a full interval after joining
+	for _, t := range c.timers {
+		t.lastPost = parent.now()
+	}
+
+	for name, t := range c.triggers {
+		if err := t.compile(); err != nil {
+			c.Println("Skipping trigger", name, err)
+			delete(c.triggers, name)
+		}
+	}
+
+	for _, e := range blacklist {
+		if err := e.compile(); err != nil {
+			c.Println("Skipping blacklist entry", e.Pattern, err)
+			continue
+		}
+		c.blacklist[e.Pattern] = e
 	}
 
-	addhelp := func() {
+	addhelp := func(gistUrl string) {
 		if c.CommandExists("help") {
 			return
 		}
-		c.AddCommand("help", fmt.Sprintf("Command list: %s",
-			parent.db.getGist(c.name)))
+		err := c.AddCommand("help", fmt.Sprintf("Command list: %s", gistUrl))
+		if err != nil {
+			c.Println("Failed to add help:", err)
+		}
 	}
 
 	// if the gist is already initialized, add help now and update the gist
-	gistInitialized := parent.db.getGist(c.name) != ""
+	gistInitialized := gistUrl != ""
 
 	if gistInitialized {
-		addhelp()
+		addhelp(gistUrl)
 	}
 
 	// refresh gist
@@ -75,25 +208,31 @@ func newChannel(parent *Bot, name string) *Channel {
 
 	// if the gist wasn't initialized earlier, add help now and update the gist
 	if !gistInitialized {
-		addhelp()
-		parent.updateCommandList(c)
+		gistUrl, err = db.Gist(c.name)
+		if err != nil {
+			c.Println("Failed to load gist:", err)
+		} else {
+			addhelp(gistUrl)
+			parent.updateCommandList(c)
+		}
 	}
 
-	return c
+	return c, nil
 }
 
 func (c *Channel) filename() string {
 	return fmt.Sprintf("%s.txt", c.name[1:])
 }
 
-// Printf calls fmt.Printf with the channel name as a prefix to the text.
+// Printf logs the text with the channel name as a prefix.
 func (c *Channel) Printf(format string, args ...interface{}) {
-	fmt.Printf(fmt.Sprintf("%s> %s", c.name, format), args...)
+	c.parent.log.Printf(fmt.Sprintf("%s> %s", c.name, format), args...)
 }
 
-// Println calls fmt.Println with the channel name as a prefix to the text.
+// Println logs the text with the channel name as a prefix.
 func (c *Channel) Println(args ...interface{}) {
-	fmt.Println(append([]interface{}{fmt.Sprintf("%s>", c.name)}, args...)...)
+	c.parent.log.Println(
+		append([]interface{}{fmt.Sprintf("%s>", c.name)}, args...)...)
 }
 
 // Privmsgf formats and sends a rate-limited message.
@@ -101,6 +240,19 @@ func (c *Channel) Privmsgf(format string, args ...interface{}) {
 	c.parent.Privmsgf(c.name, format, args...)
 }
 
+// ModPrivmsgf sends a moderation command to the channel ahead of any other
+// queued message (see Bot.ModPrivmsgf).
+func (c *Channel) ModPrivmsgf(format string, args ...interface{}) {
+	c.parent.ModPrivmsgf(c.name, format, args...)
+}
+
+// Whisperf privately messages nick. Whispers are never split, so they should
+// be short.
+func (c *Channel) Whisperf(nick, format string, args ...interface{}) {
+	c.parent.enqueue(c.name, []string{fmt.Sprintf("/w %s %s", nick,
+		fmt.Sprintf(format, args...))}, false)
+}
+
 // AddMod allows nick to use mod commands.
 func (c *Channel) AddMod(nick string) {
 	c.Println("Adding mod", nick)
@@ -125,80 +277,156 @@ func (c *Channel) IsMod(nick string) bool {
 
 // FullCommandList retrieves a list of the commands and their description (or
 // text if they are simple text commands) separated by the string separator.
-// Mod commands will be prefixed by the modPrefix string.
+// Each command is prefixed by the string permPrefix returns for the
+// command's permission level.
 // If noDescription is true, description or text will be omitted.
 // The list is alphabetically sorted.
-func (c *Channel) FullCommandList(separator, modPrefix string,
-	noDescription bool) (res string) {
+func (c *Channel) FullCommandList(separator string,
+	permPrefix func(Permission) string, noDescription bool) string {
 
-	// sort commands by name
-	sorted := make([]string, 0)
+	commands := make([]Command, 0)
 	c.parent.w.Await(func() {
 		for name := range c.commands {
-			sorted = append(sorted, name)
-		}
-	})
-	sort.Strings(sorted)
-
-	for _, name := range sorted {
-		command := c.Command(name)
-		line := ""
-		if noDescription {
-			line = fmt.Sprintf("!%s%s", name, separator)
-		} else {
-			line = fmt.Sprintf("!%s: %s%s", name, command.Text, separator)
+			commands = append(commands, c.copyCommand(name))
 		}
-		if command.ModOnly {
-			line = fmt.Sprintf("%s%s", modPrefix, line)
+		for name := range c.counters {
+			commands = append(commands, &counterCommand{c, name, 0})
 		}
-		res += line
-	}
-
-	// remove last trailing separator
-	if len(sorted) > 0 {
-		res = res[:len(res)-len(separator)]
-	}
-	return
+	})
+	return formatCommandList(commands, separator, permPrefix, noDescription)
 }
 
 // CommandList returns a comma-separated list of the commands, prefixing
 // moderator commands with a +.
 // The list is alphabetically sorted.
 func (c *Channel) CommandList() string {
-	return c.FullCommandList(", ", "+", true)
+	return c.FullCommandList(", ", func(perm Permission) string {
+		if perm >= PermModerator {
+			return "+"
+		}
+		return ""
+	}, true)
 }
 
 // Command returns a copy of the desired command.
 func (c *Channel) Command(name string) *TextCommand {
 	resp := make(chan *TextCommand, 1)
 	c.parent.w.Do(func() {
-		if c.commands[name] == nil {
-			resp <- nil
+		resp <- c.copyCommand(name)
+		close(resp)
+	})
+	return <-resp
+}
+
+// copies a command and fills in its aliases. must be called from the worker.
+func (c *Channel) copyCommand(name string) *TextCommand {
+	if c.commands[name] == nil {
+		return nil
+	}
+
+	cp := *c.commands[name]
+	cp.aliases = nil
+	for alias, command := range c.aliases {
+		if command == name {
+			cp.aliases = append(cp.aliases, alias)
+		}
+	}
+	sort.Strings(cp.aliases)
+	return &cp
+}
+
+// lookupCommand finds a text command, alias or counter command called cmd.
+// Returns nil if none exist.
+func (c *Channel) lookupCommand(cmd string) Command {
+	if text := c.Command(c.ResolveAlias(cmd)); text != nil {
+		return text
+	}
+	if counter := c.counterCommand(cmd); counter != nil {
+		return counter
+	}
+	return nil
+}
+
+// ResolveAlias returns the name of the command that name is an alias of, or
+// name itself if it's not an alias.
+func (c *Channel) ResolveAlias(name string) string {
+	resp := make(chan string, 1)
+	c.parent.w.Do(func() {
+		if command, ok := c.aliases[name]; ok {
+			resp <- command
 		} else {
-			cp := *c.commands[name]
-			resp <- &cp
+			resp <- name
 		}
 		close(resp)
 	})
 	return <-resp
 }
 
-// AddCommand adds a simple text command.
+// AddAlias makes alias an alternative name for a simple text command.
+func (c *Channel) AddAlias(alias, name string) error {
+	name = c.ResolveAlias(name)
+	if !c.CommandExists(name) {
+		return fmt.Errorf("Command %s doesn't exist.", name)
+	}
+
+	if c.CommandExists(alias) || c.ResolveAlias(alias) != alias ||
+		c.CounterExists(alias) || c.parent.CommandExists(alias) {
+
+		return fmt.Errorf("Command %s already exists.", alias)
+	}
+
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.AddAlias(c.name, alias, name)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.parent.w.Await(func() { c.aliases[alias] = name })
+	c.Println("Added alias", alias, "->", name)
+	return nil
+}
+
+// RemoveAlias removes an alias without affecting the command it refers to.
+func (c *Channel) RemoveAlias(alias string) error {
+	if c.ResolveAlias(alias) == alias {
+		return fmt.Errorf("Alias %s doesn't exist.", alias)
+	}
+
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.RemoveAlias(c.name, alias, "")
+	})
+	if err != nil {
+		return err
+	}
+
+	c.parent.w.Await(func() { delete(c.aliases, alias) })
+	c.Println("Removed alias", alias)
+	return nil
+}
+
+// AddCommand adds a simple text command. Returns an error if text contains
+// malformed template variables.
 func (c *Channel) AddCommand(name, text string) error {
-	if c.CommandExists(name) {
+	if c.CommandExists(name) || c.ResolveAlias(name) != name ||
+		c.CounterExists(name) {
+
 		return fmt.Errorf("Command %s already exists.", name)
 	}
 
-	err := attemptQuery(func() error {
-		return c.parent.db.setCommand(c.name, name, text, false)
+	if _, err := parseTemplate(text); err != nil {
+		return err
+	}
+
+	command := &TextCommand{Text: text, Perm: PermEveryone, name: name}
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.SetCommand(c.name, name, command)
 	})
 	if err != nil {
 		return err
 	}
 
-	c.parent.w.Await(func() {
-		c.commands[name] = &TextCommand{Text: text, ModOnly: false}
-	})
+	c.parent.w.Await(func() { c.commands[name] = command })
 	c.Println("Added command", name, "->", text)
 	return nil
 }
@@ -209,27 +437,47 @@ func (c *Channel) RemoveCommand(name string) error {
 		return fmt.Errorf("Command %s doesn't exist.", name)
 	}
 
-	err := attemptQuery(func() error {
-		return c.parent.db.removeCommand(c.name, name)
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.RemoveCommand(c.name, name)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.parent.attemptQuery(func() error {
+		return c.parent.db.RemoveAlias(c.name, "", name)
 	})
 	if err != nil {
 		return err
 	}
 
-	c.parent.w.Await(func() { delete(c.commands, name) })
+	c.parent.w.Await(func() {
+		delete(c.commands, name)
+		for alias, command := range c.aliases {
+			if command == name {
+				delete(c.aliases, alias)
+			}
+		}
+	})
 	c.Println("Removed command", name)
 	return nil
 }
 
 // EditCommand replaces the text of an existing simple text command.
+// Returns an error if text contains malformed template variables.
 func (c *Channel) EditCommand(name, text string) error {
 	if !c.CommandExists(name) {
 		return fmt.Errorf("Command %s doesn't exist.", name)
 	}
 
-	err := attemptQuery(func() error {
+	if _, err := parseTemplate(text); err != nil {
+		return err
+	}
+
+	err := c.parent.attemptQuery(func() error {
 		co := c.Command(name)
-		return c.parent.db.setCommand(c.name, name, text, co.ModOnly)
+		co.Text = text
+		return c.parent.db.SetCommand(c.name, name, co)
 	})
 	if err != nil {
 		return err
@@ -242,20 +490,87 @@ func (c *Channel) EditCommand(name, text string) error {
 
 // SetCommandMod sets whether a command is for mods only or not.
 func (c *Channel) SetCommandMod(name string, modOnly bool) error {
+	if modOnly {
+		return c.SetCommandPermission(name, PermModerator)
+	}
+	return c.SetCommandPermission(name, PermEveryone)
+}
+
+// SetCommandPermission sets the minimum role required to use a command.
+func (c *Channel) SetCommandPermission(name string, perm Permission) error {
 	if !c.CommandExists(name) {
 		return fmt.Errorf("Command %s doesn't exist.", name)
 	}
 
-	err := attemptQuery(func() error {
+	err := c.parent.attemptQuery(func() error {
 		co := c.Command(name)
-		return c.parent.db.setCommand(
-			c.name, name, co.Text, modOnly)
+		co.Perm = perm
+		return c.parent.db.SetCommand(c.name, name, co)
 	})
 	if err != nil {
 		return err
 	}
 
-	c.parent.w.Await(func() { c.commands[name].ModOnly = modOnly })
+	c.parent.w.Await(func() { c.commands[name].Perm = perm })
+	c.Println("Set permission for command", name, "->", perm)
+	return nil
+}
+
+// SetCommandCooldown sets the global and per-user cooldowns of a command and
+// whether mods are exempt from them.
+func (c *Channel) SetCommandCooldown(name string, global, user time.Duration,
+	modsExempt bool) error {
+
+	if !c.CommandExists(name) {
+		return fmt.Errorf("Command %s doesn't exist.", name)
+	}
+
+	err := c.parent.attemptQuery(func() error {
+		co := c.Command(name)
+		co.GlobalCooldown = global
+		co.UserCooldown = user
+		co.ModsExempt = modsExempt
+		return c.parent.db.SetCommand(c.name, name, co)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.parent.w.Await(func() {
+		co := c.commands[name]
+		co.GlobalCooldown = global
+		co.UserCooldown = user
+		co.ModsExempt = modsExempt
+	})
+	c.Println("Set cooldown for command", name, "-> global", global,
+		"user", user, "mods exempt", modsExempt)
+	return nil
+}
+
+// Cooldown returns the default cooldown for commands that don't set their
+// own.
+func (c *Channel) Cooldown() time.Duration {
+	resp := make(chan int32, 1)
+	c.parent.w.Do(func() {
+		resp <- c.commandCooldown
+		close(resp)
+	})
+	return time.Millisecond * time.Duration(<-resp)
+}
+
+// SetCooldown sets the default cooldown for commands that don't set their
+// own. The cooldown is saved and restored on start-up.
+func (c *Channel) SetCooldown(cooldown time.Duration) error {
+	ms := int32(cooldown / time.Millisecond)
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.SetChannelCooldown(c.name, ms)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.parent.w.Await(func() { c.commandCooldown = ms })
+	c.Println("Set default cooldown to", cooldown)
 	return nil
 }
 
@@ -264,31 +579,97 @@ func (c *Channel) CommandExists(name string) bool {
 	return c.Command(name) != nil
 }
 
-func (c *Channel) onCommand(commandName, nick string) bool {
-	if !c.CommandExists(commandName) {
-		// should only return false when the command doesn't exist
-		return false
+// increments the usage count of a text command and returns the new count.
+func (c *Channel) incrementUses(name string) int {
+	resp := make(chan int, 1)
+	c.parent.w.Do(func() {
+		command := c.commands[name]
+		if command == nil {
+			resp <- 0
+		} else {
+			command.Uses++
+			resp <- command.Uses
+		}
+		close(resp)
+	})
+	uses := <-resp
+
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.SetCommandUses(c.name, name, uses)
+	})
+	if err != nil {
+		c.Println("Failed to save usage count for", name, err)
+	}
+	return uses
+}
+
+// Uptime asks the helix api how long the channel has been live. online is
+// false if the channel isn't streaming. This needs the credentials set
+// through WithTwitchAPI.
+func (c *Channel) Uptime() (uptime time.Duration, online bool, err error) {
+	if len(c.parent.clientID) == 0 {
+		err = fmt.Errorf("no twitch api credentials")
+		return
+	}
+
+	roomID := c.RoomID()
+	if len(roomID) == 0 {
+		err = fmt.Errorf("the id of %s isn't known yet", c.name)
+		return
 	}
 
-	command := c.Command(commandName)
+	var res struct {
+		Data []struct {
+			StartedAt time.Time `json:"started_at"`
+		} `json:"data"`
+	}
+	err = c.parent.helix("streams", url.Values{"user_id": {roomID}}, &res)
+	if err != nil || len(res.Data) == 0 {
+		return
+	}
+
+	online = true
+	uptime = c.parent.since(res.Data[0].StartedAt) / time.Second * time.Second
+	return
+}
+
+// RoomID returns the twitch user id of the channel, or an empty string if
+// twitch hasn't sent it yet.
+func (c *Channel) RoomID() string {
+	resp := make(chan string, 1)
+	c.parent.w.Do(func() {
+		resp <- c.roomID
+		close(resp)
+	})
+	return <-resp
+}
 
-	cd := c.commandCooldown
-	elapsed := time.Since(command.LastUsage)
-	cooldown := time.Millisecond * time.Duration(cd)
-	if elapsed < cooldown {
-		c.Println("Rejected command", commandName, "because it is still on "+
-			"cooldown,", elapsed, "since last usage, cooldown is", cooldown)
-		return true
+func (c *Channel) setRoomID(id string) {
+	c.parent.w.Await(func() { c.roomID = id })
+}
+
+// Game asks the helix api which game the channel is set to. The game is
+// empty if the bot wasn't given api credentials through WithTwitchAPI.
+func (c *Channel) Game() (game string, err error) {
+	if len(c.parent.clientID) == 0 {
+		return
 	}
 
-	if command.ModOnly && !c.IsMod(nick) {
-		c.Println("Rejected command", commandName,
-			"because the user is not a mod")
-		return true
+	roomID := c.RoomID()
+	if len(roomID) == 0 {
+		err = fmt.Errorf("the id of %s isn't known yet", c.name)
+		return
 	}
 
-	c.parent.w.Await(func() { c.commands[commandName].LastUsage = time.Now() })
-	c.Println("Processing text command", commandName)
-	c.Privmsgf("%s", command.Text)
-	return true
+	var res struct {
+		Data []struct {
+			GameName string `json:"game_name"`
+		} `json:"data"`
+	}
+	err = c.parent.helix("channels", url.Values{"broadcaster_id": {roomID}},
+		&res)
+	if err == nil && len(res.Data) != 0 {
+		game = res.Data[0].GameName
+	}
+	return
 }
@@ -0,0 +1,299 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Command is anything that can be invoked from chat with a ! prefix.
+// Built-in commands, commands added through Bot.AddCommand and simple text
+// commands all implement it, so permissions, cooldowns, the ignore list,
+// logging and the command list gist work the same for every command.
+type Command interface {
+	// Name is the name the command is invoked with, without the ! prefix.
+	Name() string
+	// Aliases are alternative names for the command.
+	Aliases() []string
+	// Permission is the minimum role required to use the command.
+	Permission() Permission
+	// Cooldown is the minimum time between two uses of the command in a
+	// channel. Zero means that the channel's default cooldown applies.
+	Cooldown() time.Duration
+	// Help is the description shown in the command list.
+	Help() string
+	// Run executes the command.
+	Run(c *CommandData)
+}
+
+// A UserCooldownCommand is a Command that can also limit how often each user
+// can use it.
+type UserCooldownCommand interface {
+	Command
+	// PerUserCooldown is the minimum time between two uses of the command by
+	// the same user.
+	PerUserCooldown() time.Duration
+	// ExemptsMods is true when moderators ignore the command's cooldowns.
+	ExemptsMods() bool
+}
+
+// CommandData holds information about a chat message that contains a recognized
+// command.
+type CommandData struct {
+	// Channel is a pointer to the channel where this message originated.
+	Channel *Channel
+	// Args contains every parameter after the command name. It is built using
+	// strings.Fields, so repeated whitespace is ignored.
+	Args []string
+	// Nick is the nickname of the user that sent the command.
+	Nick string
+	// User holds the badges and other information twitch sent about the user
+	// that sent the command.
+	User *UserInfo
+}
+
+// IsMod returns whether the user that sent the command is allowed to use mod
+// commands. Badges are checked first, as they are available immediately,
+// falling back to the channel's operator list.
+func (c *CommandData) IsMod() bool {
+	return c.User.IsMod() || c.Channel.IsMod(c.Nick)
+}
+
+// a Command backed by a go function.
+type handlerCommand struct {
+	name       string
+	aliases    []string
+	permission Permission
+	cooldown   time.Duration
+	help       string
+	handler    func(*CommandData)
+}
+
+func (h *handlerCommand) Name() string            { return h.name }
+func (h *handlerCommand) Aliases() []string       { return h.aliases }
+func (h *handlerCommand) Permission() Permission  { return h.permission }
+func (h *handlerCommand) Cooldown() time.Duration { return h.cooldown }
+func (h *handlerCommand) Help() string            { return h.help }
+func (h *handlerCommand) Run(c *CommandData)      { h.handler(c) }
+
+// NewCommand creates a Command that calls handler when invoked.
+func NewCommand(name string, perm Permission, cooldown time.Duration,
+	help string, handler func(*CommandData), aliases ...string) Command {
+
+	return &handlerCommand{name, aliases, perm, cooldown, help, handler}
+}
+
+// wraps a Command to change its permission level.
+type permissionOverride struct {
+	Command
+	permission Permission
+}
+
+func (p permissionOverride) Permission() Permission { return p.permission }
+
+// strips ! from a command name if present.
+func parseCommandName(str string) string {
+	if str[0] == '!' {
+		str = str[1:]
+	}
+	return str
+}
+
+// Register adds a command to every channel, replacing any command with the
+// same name or alias.
+func (b *Bot) Register(command Command) {
+	b.w.Await(func() {
+		b.commands[command.Name()] = command
+		for _, alias := range command.Aliases() {
+			b.commands[alias] = command
+		}
+	})
+}
+
+// AddCommand adds a command and binds it to handler. The command can be used
+// by everyone until restricted through SetCommandPermission.
+func (b *Bot) AddCommand(name string, handler func(*CommandData)) {
+	b.Register(NewCommand(name, PermEveryone, 0, "", handler))
+}
+
+// RemoveCommand removes a command along with its aliases.
+func (b *Bot) RemoveCommand(name string) {
+	b.w.Await(func() {
+		command := b.commands[name]
+		if command == nil {
+			return
+		}
+		delete(b.commands, command.Name())
+		for _, alias := range command.Aliases() {
+			delete(b.commands, alias)
+		}
+	})
+}
+
+// SetCommandPermission sets the minimum role required to use a command.
+func (b *Bot) SetCommandPermission(name string, perm Permission) {
+	command := b.Command(name)
+	if command == nil {
+		return
+	}
+	if override, ok := command.(permissionOverride); ok {
+		command = override.Command
+	}
+	b.Register(permissionOverride{command, perm})
+}
+
+// CommandExists returns whether the command exists.
+func (b *Bot) CommandExists(name string) bool {
+	return b.Command(name) != nil
+}
+
+// Command returns a command by name or alias.
+func (b *Bot) Command(name string) Command {
+	resp := make(chan Command, 1)
+	b.w.Do(func() {
+		resp <- b.commands[name]
+		close(resp)
+	})
+	return <-resp
+}
+
+// FullCommandList retrieves a list of the commands that are available on
+// every channel, formatted like Channel.FullCommandList.
+func (b *Bot) FullCommandList(separator string,
+	permPrefix func(Permission) string, noDescription bool) string {
+
+	commands := make([]Command, 0)
+	b.w.Await(func() {
+		for name, command := range b.commands {
+			// skip aliases
+			if name == command.Name() {
+				commands = append(commands, command)
+			}
+		}
+	})
+	return formatCommandList(commands, separator, permPrefix, noDescription)
+}
+
+type commandsByName []Command
+
+func (c commandsByName) Len() int           { return len(c) }
+func (c commandsByName) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c commandsByName) Less(i, j int) bool { return c[i].Name() < c[j].Name() }
+
+// sorts commands by name and joins them with separator.
+func formatCommandList(commands []Command, separator string,
+	permPrefix func(Permission) string, noDescription bool) (res string) {
+
+	sort.Sort(commandsByName(commands))
+
+	for _, command := range commands {
+		name := "!" + command.Name()
+		if !noDescription && len(command.Aliases()) != 0 {
+			name += " (!" + strings.Join(command.Aliases(), ", !") + ")"
+		}
+
+		line := ""
+		if noDescription || len(command.Help()) == 0 {
+			line = fmt.Sprintf("%s%s", name, separator)
+		} else {
+			line = fmt.Sprintf("%s: %s%s", name, command.Help(), separator)
+		}
+		res += permPrefix(command.Permission()) + line
+	}
+
+	// remove last trailing separator
+	if len(commands) > 0 {
+		res = res[:len(res)-len(separator)]
+	}
+	return
+}
+
+// runCommand checks the ignore list, permissions and cooldown for command
+// and runs it if they all pass.
+func (c *Channel) runCommand(command Command, data *CommandData) {
+	name := command.Name()
+
+	if c.parent.Ignored(data.Nick) {
+		c.Println("Ignored command", name, "from", data.Nick)
+		return
+	}
+
+	perm := command.Permission()
+	if !c.HasPermission(data.User, perm) {
+		c.Println("Rejected command", name, "because the user is not", perm)
+		return
+	}
+
+	cooldown := command.Cooldown()
+	if cooldown == 0 {
+		cooldown = c.Cooldown()
+	}
+
+	var userCooldown time.Duration
+	if uc, ok := command.(UserCooldownCommand); ok {
+		if uc.ExemptsMods() && data.IsMod() {
+			cooldown = 0
+		} else {
+			userCooldown = uc.PerUserCooldown()
+		}
+	}
+
+	userKey := name + " " + data.Nick
+	resp := make(chan string, 1)
+	c.parent.w.Do(func() {
+		now := c.parent.now()
+		elapsed := now.Sub(c.lastUsage[name])
+		userEnd := c.userCooldowns[userKey]
+		switch {
+		case elapsed < cooldown:
+			resp <- fmt.Sprint(elapsed, " since last usage, cooldown is ",
+				cooldown)
+		case now.Before(userEnd):
+			resp <- fmt.Sprint(userEnd.Sub(now), " left on ", data.Nick,
+				"'s user cooldown of ", userCooldown)
+		default:
+			c.lastUsage[name] = now
+			c.pruneUserCooldowns(now)
+			if userCooldown > 0 {
+				c.userCooldowns[userKey] = now.Add(userCooldown)
+			}
+			resp <- ""
+		}
+		close(resp)
+	})
+
+	if reason := <-resp; len(reason) != 0 {
+		c.Println("Rejected command", name, "because it is still on "+
+			"cooldown,", reason)
+		return
+	}
+
+	c.Println("Processing command", name, data.Args)
+	command.Run(data)
+}
+
+// forgets the per-user cooldowns that are over, so the map only holds users
+// that are still on cooldown. Must run on the worker.
+func (c *Channel) pruneUserCooldowns(now time.Time) {
+	for key, end := range c.userCooldowns {
+		if !now.Before(end) {
+			delete(c.userCooldowns, key)
+		}
+	}
+}
@@ -19,59 +19,159 @@ import (
 	"fmt"
 	"github.com/Francesco149/shigebot/shige/gist"
 	"io/ioutil"
-	"strings"
 )
 
 const (
-	githubApi = "https://api.github.com/"
-	gistDesc  = "Shigebot Commands for "
+	githubApi   = "https://api.github.com/"
+	gistDesc    = "Shigebot Commands for "
+	modGistDesc = "Shigebot moderation lists for "
 )
 
+// A Publisher uploads the files that list a channel's commands and quotes so
+// they can be linked from chat.
+type Publisher interface {
+	// Publish uploads files, replacing the ones previously published at url
+	// if it's not empty. Returns the url of the upload. Uploads that aren't
+	// public must only be reachable by those who know the url.
+	Publish(url, description string, files []string, public bool) (string,
+		error)
+}
+
+// gistPublisher uploads command lists to github gist, which is the default.
+type gistPublisher struct{ b *Bot }
+
+func (p *gistPublisher) Publish(url, description string, files []string,
+	public bool) (string, error) {
+
+	if url == "" {
+		return gist.Post(p.b.http, githubApi, p.b.gistOAuth, public, files,
+			description)
+	}
+	return url, gist.Update(p.b.http, githubApi, p.b.gistOAuth, files, url,
+		description)
+}
+
 func (b *Bot) updateCommandList(ch *Channel) {
 	channel := ch.name
 	commands := fmt.Sprintf(
 		`# %s
 by Franc\[e\]sco / lolisamurai
 
-Available commands for channel [%s](http://www.twitch.tv/%s) (+ = mod only):
+Available commands for channel [%s](http://www.twitch.tv/%s) (the role
+required to use a command is shown in brackets, commands without one can be
+used by everyone):
 
-%s
 `,
-		BotName, channel, channel[1:], b.BuiltinCommandsInfo)
+		BotName, channel, channel[1:])
 
-	list := ch.FullCommandList("\n", "+", false)
-	lines := strings.Split(list, "\n")
-	for _, line := range lines {
-		commands += "* " + line + "\n"
+	permPrefix := func(perm Permission) string {
+		if perm == PermEveryone {
+			return "* "
+		}
+		return fmt.Sprintf("* [%s] ", perm)
 	}
+	commands += b.FullCommandList("\n", permPrefix, false) + "\n"
+	commands += ch.FullCommandList("\n", permPrefix, false) + "\n"
 
 	filename := fmt.Sprintf("commands-for-%s.md", channel[1:])
 	err := ioutil.WriteFile(filename, []byte(commands), 0777)
 	if err != nil {
-		panic(err)
-	}
-
-	for {
-		if !b.db.gistExists(channel) {
-			url, err := gist.Post(githubApi, b.gistOAuth, true,
-				[]string{filename}, gistDesc+channel)
-			if err != nil {
-				fmt.Println(err)
-				break
-			}
-			err = attemptQuery(func() error {
-				return b.db.setGist(channel, url)
-			})
-		} else {
-			url := b.db.getGist(channel)
-			err = gist.Update(githubApi, b.gistOAuth, []string{filename},
-				url, gistDesc+channel)
-		}
+		b.log.Println("Failed to write the command list:", err)
+		return
+	}
+
+	quotes, err := ch.QuoteList()
+	if err != nil {
+		b.log.Println("Failed to load quotes for the command list:", err)
+		return
+	}
+	if len(quotes) == 0 {
+		quotes = "No quotes yet, add one with !quote add text\n"
+	}
+	quotes = fmt.Sprintf("# Quotes for %s\n\n%s", channel[1:], quotes)
+
+	quotesFilename := fmt.Sprintf("quotes-for-%s.md", channel[1:])
+	err = ioutil.WriteFile(quotesFilename, []byte(quotes), 0777)
+	if err != nil {
+		b.log.Println("Failed to write the quote list:", err)
+		return
+	}
+
+	files := []string{filename, quotesFilename}
+
+	var oldUrl string
+	err = b.attemptQuery(func() (err error) {
+		oldUrl, err = b.db.Gist(channel)
+		return
+	})
+	if err != nil {
+		b.log.Println("Failed to load the command list gist:", err)
+		return
+	}
 
-		break
+	url, err := b.publisher.Publish(oldUrl, gistDesc+channel, files, true)
+	if err == nil && url != oldUrl {
+		err = b.attemptQuery(func() error {
+			return b.db.SetGist(channel, url)
+		})
 	}
 
 	if err != nil {
-		fmt.Println("Failed to update command list gist, will retry next time!")
+		b.log.Println("Failed to update command list gist, will retry "+
+			"next time!", err)
+	}
+}
+
+// updateModList publishes the channel's moderation log and blacklist to a
+// secret gist, so only moderators that are given the link can see them.
+// Returns the link.
+func (b *Bot) updateModList(ch *Channel) (url string, err error) {
+	channel := ch.name
+
+	modlog, err := ch.AuditLogList()
+	if err != nil {
+		return
+	}
+	if len(modlog) == 0 {
+		modlog = "Nothing yet.\n"
+	}
+	modlog = fmt.Sprintf("# Moderation log for %s\n\n%s", channel[1:],
+		modlog)
+
+	filename := fmt.Sprintf("modlog-for-%s.md", channel[1:])
+	err = ioutil.WriteFile(filename, []byte(modlog), 0600)
+	if err != nil {
+		return
+	}
+
+	blacklist := ch.BlacklistList()
+	if len(blacklist) == 0 {
+		blacklist = "Nothing is blacklisted.\n"
+	}
+	blacklist = fmt.Sprintf("# Blacklist for %s\n\n%s", channel[1:],
+		blacklist)
+
+	blacklistFilename := fmt.Sprintf("blacklist-for-%s.md", channel[1:])
+	err = ioutil.WriteFile(blacklistFilename, []byte(blacklist), 0600)
+	if err != nil {
+		return
+	}
+
+	var oldUrl string
+	err = b.attemptQuery(func() (err error) {
+		oldUrl, err = b.db.ModGist(channel)
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	url, err = b.publisher.Publish(oldUrl, modGistDesc+channel,
+		[]string{filename, blacklistFilename}, false)
+	if err == nil && url != oldUrl {
+		err = b.attemptQuery(func() error {
+			return b.db.SetModGist(channel, url)
+		})
 	}
+	return
 }
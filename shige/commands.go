@@ -16,9 +16,7 @@
 package shige
 
 import (
-	"encoding/json"
 	"fmt"
-	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -27,78 +25,13 @@ import (
 // I'm aware all of these could be methods for Channel but I prefer keeping the
 // built-in commands outside of the channel entity.
 
-// CommandData holds information about a chat message that contains a recognized
-// command.
-type CommandData struct {
-	// Channel is a pointer to the channel where this message originated.
-	Channel *Channel
-	// Args contains every parameter after the command name. It is built using
-	// strings.Fields, so repeated whitespace is ignored.
-	Args []string
-	// Nick is the nickname of the user that sent the command.
-	Nick string
-}
-
-// strips ! from a command name if present.
-func parseCommandName(str string) string {
-	if str[0] == '!' {
-		str = str[1:]
-	}
-	return str
-}
-
-// AddCommand adds a command and binds it to handler.
-func (b *Bot) AddCommand(name string, handler func(*CommandData)) {
-	b.w.Await(func() { b.commands[name] = handler })
-}
-
-// RemoveCommand removes a command.
-func (b *Bot) RemoveCommand(name string) {
-	b.w.Await(func() { delete(b.commands, name) })
-}
-
-// CommandExists returns whether the command exists.
-func (b *Bot) CommandExists(name string) bool {
-	resp := make(chan bool, 1)
-	b.w.Do(func() {
-		resp <- b.commands[name] != nil
-		close(resp)
-	})
-	return <-resp
-}
-
-// Command returns a command's handler.
-func (b *Bot) Command(name string) func(*CommandData) {
-	resp := make(chan func(*CommandData), 1)
-	b.w.Do(func() {
-		resp <- b.commands[name]
-		close(resp)
-	})
-	return <-resp
-}
-
-func (b *Bot) isCooldown(ch *Channel, command string) bool {
-	resp := make(chan bool, 1)
-	b.w.Do(func() {
-		res := time.Since(ch.builtinLastUsage[command]) <
-			time.Duration(ch.commandCooldown)*time.Millisecond
-		if !res {
-			ch.builtinLastUsage[command] = time.Now()
-		}
-		resp <- res
-		close(resp)
-	})
-	return <-resp
-}
-
 func (b *Bot) initCommands() {
-	// TODO: join builtin commands with the channel commands somehow
-	b.commands = map[string]func(*CommandData){
-		"cmdadd": func(c *CommandData) {
+	b.commands = make(map[string]Command)
+
+	b.Register(NewCommand("cmdadd", PermModerator, 0,
+		"adds a command (Usage: !cmdadd commandname text)",
+		func(c *CommandData) {
 			ch := c.Channel
-			if !ch.IsMod(c.Nick) {
-				return
-			}
 			if len(c.Args) < 2 {
 				ch.Privmsgf("Usage: !cmdadd commandname text")
 				return
@@ -119,15 +52,15 @@ func (b *Bot) initCommands() {
 				return
 			}
 
+			ch.Audit(c.Nick, "cmdadd", commandName, "", commandText)
 			ch.Privmsgf("Added command %s", commandName)
 			b.updateCommandList(c.Channel)
-		},
+		}))
 
-		"cmdremove": func(c *CommandData) {
+	b.Register(NewCommand("cmdremove", PermModerator, 0,
+		"removes a command (Usage: !cmdremove commandname)",
+		func(c *CommandData) {
 			ch := c.Channel
-			if !ch.IsMod(c.Nick) {
-				return
-			}
 			if len(c.Args) != 1 {
 				ch.Privmsgf("Usage: !cmdremove commandname")
 				return
@@ -142,21 +75,23 @@ func (b *Bot) initCommands() {
 				return
 			}
 
+			before := ch.Command(commandName)
 			err := ch.RemoveCommand(commandName)
 			if err != nil {
 				ch.Privmsgf("%v", err)
 				return
 			}
 
+			ch.Audit(c.Nick, "cmdremove", commandName, before.Text, "")
 			ch.Privmsgf("Removed command %s", commandName)
 			b.updateCommandList(c.Channel)
-		},
+		}))
 
-		"cmdedit": func(c *CommandData) {
+	b.Register(NewCommand("cmdedit", PermModerator, 0,
+		"changes the text for a command "+
+			"(Usage: !cmdedit commandname text)",
+		func(c *CommandData) {
 			ch := c.Channel
-			if !ch.IsMod(c.Nick) {
-				return
-			}
 			if len(c.Args) < 2 {
 				ch.Privmsgf("Usage: !cmdedit commandname text")
 				return
@@ -172,21 +107,22 @@ func (b *Bot) initCommands() {
 			}
 
 			commandText := strings.Join(c.Args[1:], " ")
+			before := ch.Command(commandName)
 			err := ch.EditCommand(commandName, commandText)
 			if err != nil {
 				ch.Privmsgf("%v", err)
 				return
 			}
 
+			ch.Audit(c.Nick, "cmdedit", commandName, before.Text, commandText)
 			ch.Privmsgf("Edited command %s", commandName)
 			b.updateCommandList(c.Channel)
-		},
+		}))
 
-		"modonly": func(c *CommandData) {
+	b.Register(NewCommand("modonly", PermModerator, 0,
+		"limits a command to mods only (Usage: !modonly commandname yes/no)",
+		func(c *CommandData) {
 			ch := c.Channel
-			if !ch.IsMod(c.Nick) {
-				return
-			}
 			if len(c.Args) != 2 || (c.Args[1] != "yes" && c.Args[1] != "no") {
 				ch.Privmsgf("Usage: !modonly commandname yes/no")
 				return
@@ -202,28 +138,170 @@ func (b *Bot) initCommands() {
 			}
 
 			toggle := c.Args[1] == "yes"
+			before := ch.Command(commandName)
 			err := ch.SetCommandMod(commandName, toggle)
 			if err != nil {
 				ch.Privmsgf("%v", err)
 				return
 			}
 
+			after := ch.Command(commandName)
+			ch.Audit(c.Nick, "modonly", commandName, before.Perm.String(),
+				after.Perm.String())
+
 			ch.Privmsgf("Command %s modonly = %v.", commandName, toggle)
 			b.updateCommandList(c.Channel)
-		},
+		}))
+
+	b.Register(NewCommand("cmdperm", PermModerator, 0,
+		"sets the role required to use a command (Usage: !cmdperm "+
+			"commandname everyone/follower/subscriber/vip/moderator/"+
+			"broadcaster/owner)",
+		func(c *CommandData) {
+			ch := c.Channel
+			if len(c.Args) != 2 {
+				ch.Privmsgf("Usage: !cmdperm commandname level (levels: %s)",
+					strings.Join(permissionNames, ", "))
+				return
+			}
+
+			commandName := parseCommandName(c.Args[0])
+			if !b.caseSensitive {
+				commandName = strings.ToLower(commandName)
+			}
+			if b.CommandExists(commandName) {
+				ch.Privmsgf("Command %s cannot be edited.", commandName)
+				return
+			}
+
+			perm, err := ParsePermission(c.Args[1])
+			if err != nil {
+				ch.Privmsgf("%v", err)
+				return
+			}
+
+			// nobody should be able to lock a command above their own level
+			if !ch.HasPermission(c.User, perm) {
+				ch.Privmsgf("You can't restrict commands to %s.", perm)
+				return
+			}
+
+			before := ch.Command(commandName)
+			err = ch.SetCommandPermission(commandName, perm)
+			if err != nil {
+				ch.Privmsgf("%v", err)
+				return
+			}
+
+			ch.Audit(c.Nick, "cmdperm", commandName, before.Perm.String(),
+				perm.String())
+			ch.Privmsgf("Command %s is now usable by %s.", commandName, perm)
+			b.updateCommandList(c.Channel)
+		}))
+
+	b.Register(NewCommand("cmdalias", PermModerator, 0,
+		"adds or removes an alternative name for a command (Usage: "+
+			"!cmdalias add alias commandname, !cmdalias remove alias)",
+		func(c *CommandData) {
+			ch := c.Channel
+			usage := "Usage: !cmdalias add alias commandname, " +
+				"!cmdalias remove alias"
+			if len(c.Args) < 2 {
+				ch.Privmsgf(usage)
+				return
+			}
+
+			alias := parseCommandName(c.Args[1])
+			if !b.caseSensitive {
+				alias = strings.ToLower(alias)
+			}
+
+			switch {
+			case c.Args[0] == "add" && len(c.Args) == 3:
+				commandName := parseCommandName(c.Args[2])
+				if !b.caseSensitive {
+					commandName = strings.ToLower(commandName)
+				}
+
+				err := ch.AddAlias(alias, commandName)
+				if err != nil {
+					ch.Privmsgf("%v", err)
+					return
+				}
+
+				target := ch.ResolveAlias(alias)
+				ch.Audit(c.Nick, "cmdalias add", alias, "", target)
+				ch.Privmsgf("!%s is now an alias of !%s", alias, target)
+
+			case c.Args[0] == "remove" && len(c.Args) == 2:
+				before := ch.ResolveAlias(alias)
+				err := ch.RemoveAlias(alias)
+				if err != nil {
+					ch.Privmsgf("%v", err)
+					return
+				}
+
+				ch.Audit(c.Nick, "cmdalias remove", alias, before, "")
+				ch.Privmsgf("Removed alias %s", alias)
+
+			default:
+				ch.Privmsgf(usage)
+				return
+			}
+
+			b.updateCommandList(c.Channel)
+		}))
 
-		"cooldown": func(c *CommandData) {
+	b.Register(NewCommand("counter", PermModerator, 0,
+		"adds or removes a counter such as a death counter (Usage: "+
+			"!counter add name, !counter remove name)",
+		func(c *CommandData) {
 			ch := c.Channel
-			if !ch.IsMod(c.Nick) {
+			if len(c.Args) != 2 {
+				ch.Privmsgf("Usage: !counter add name, !counter remove name")
 				return
 			}
 
-			resp := make(chan int32, 1)
-			b.w.Do(func() {
-				resp <- ch.commandCooldown
-				close(resp)
-			})
-			cd := <-resp
+			name := parseCommandName(c.Args[1])
+			if !b.caseSensitive {
+				name = strings.ToLower(name)
+			}
+
+			switch c.Args[0] {
+			case "add":
+				err := ch.AddCounter(name)
+				if err != nil {
+					ch.Privmsgf("%v", err)
+					return
+				}
+				ch.Audit(c.Nick, "counter add", name, "", "0")
+				ch.Privmsgf("Added counter %s. Use !%s+, !%s- and !%s set n "+
+					"to change it.", name, name, name, name)
+
+			case "remove":
+				before, _ := ch.Counter(name)
+				err := ch.RemoveCounter(name)
+				if err != nil {
+					ch.Privmsgf("%v", err)
+					return
+				}
+				ch.Audit(c.Nick, "counter remove", name, strconv.Itoa(before),
+					"")
+				ch.Privmsgf("Removed counter %s", name)
+
+			default:
+				ch.Privmsgf("Usage: !counter add name, !counter remove name")
+				return
+			}
+
+			b.updateCommandList(c.Channel)
+		}))
+
+	b.Register(NewCommand("cooldown", PermModerator, 0,
+		"milliseconds before a command can be reused (Usage: !cooldown ms)",
+		func(c *CommandData) {
+			ch := c.Channel
+			cd := int64(ch.Cooldown() / time.Millisecond)
 
 			usage := fmt.Sprintf(
 				"Usage: !cooldown milliseconds. Current cooldown is %vms.", cd)
@@ -243,73 +321,779 @@ func (b *Bot) initCommands() {
 				i = 0
 			}
 
-			b.w.Await(func() { ch.commandCooldown = int32(i) })
+			err = ch.SetCooldown(time.Duration(i) * time.Millisecond)
+			if err != nil {
+				ch.Privmsgf("%v", err)
+				return
+			}
+
+			ch.Audit(c.Nick, "cooldown", "channel", fmt.Sprintf("%dms", cd),
+				fmt.Sprintf("%dms", i))
+
 			ch.Privmsgf("Command cooldown set to %v milliseconds", i)
-		},
+		}))
 
-		"uptime": func(c *CommandData) {
+	b.Register(NewCommand("cmdcooldown", PermModerator, 0,
+		"sets the cooldowns of a command, optionally exempting mods (Usage: "+
+			"!cmdcooldown commandname global=10s user=60s modexempt=yes/no)",
+		func(c *CommandData) {
 			ch := c.Channel
+			usage := "Usage: !cmdcooldown commandname global=10s user=60s " +
+				"modexempt=yes/no"
+			if len(c.Args) < 1 {
+				ch.Privmsgf(usage)
+				return
+			}
 
-			if b.isCooldown(ch, "uptime") {
-				fmt.Println("uptime is on cooldown")
+			commandName := parseCommandName(c.Args[0])
+			if !b.caseSensitive {
+				commandName = strings.ToLower(commandName)
+			}
+			if b.CommandExists(commandName) {
+				ch.Privmsgf("Command %s cannot be edited.", commandName)
 				return
 			}
 
-			req, err := http.NewRequest("GET",
-				"https://api.twitch.tv/kraken/streams/"+ch.name[1:],
-				nil)
+			co := ch.Command(commandName)
+			if co == nil {
+				ch.Privmsgf("Command %s doesn't exist.", commandName)
+				return
+			}
+
+			if len(c.Args) == 1 {
+				ch.Privmsgf("Command %s: global cooldown %v, user cooldown "+
+					"%v, mods exempt: %v. %s", commandName, co.GlobalCooldown,
+					co.UserCooldown, co.ModsExempt, usage)
+				return
+			}
+
+			// settings that aren't specified are left unchanged
+			global, user, modsExempt := co.GlobalCooldown, co.UserCooldown,
+				co.ModsExempt
+			for _, arg := range c.Args[1:] {
+				split := strings.SplitN(arg, "=", 2)
+				if len(split) != 2 {
+					ch.Privmsgf(usage)
+					return
+				}
+
+				var err error
+				switch strings.ToLower(split[0]) {
+				case "global":
+					global, err = time.ParseDuration(split[1])
+				case "user":
+					user, err = time.ParseDuration(split[1])
+				case "modexempt":
+					if split[1] != "yes" && split[1] != "no" {
+						err = fmt.Errorf("expected yes or no")
+					}
+					modsExempt = split[1] == "yes"
+				default:
+					err = fmt.Errorf("unknown setting %s", split[0])
+				}
+
+				if err == nil && (global < 0 || user < 0) {
+					err = fmt.Errorf("cooldowns can't be negative")
+				}
+
+				if err != nil {
+					ch.Privmsgf("Invalid %s: %v. %s", arg, err, usage)
+					return
+				}
+			}
+
+			err := ch.SetCommandCooldown(commandName, global, user, modsExempt)
 			if err != nil {
-				ch.Privmsgf("API error: %v", err)
+				ch.Privmsgf("%v", err)
 				return
 			}
 
-			fmt.Println(req.URL)
+			describe := func(g, u time.Duration, exempt bool) string {
+				return fmt.Sprintf("global %v, user %v, mods exempt: %v", g,
+					u, exempt)
+			}
+			ch.Audit(c.Nick, "cmdcooldown", commandName,
+				describe(co.GlobalCooldown, co.UserCooldown, co.ModsExempt),
+				describe(global, user, modsExempt))
+
+			ch.Privmsgf("Command %s: global cooldown %v, user cooldown %v, "+
+				"mods exempt: %v.", commandName, global, user, modsExempt)
+		}))
+
+	b.Register(NewCommand("timer", PermModerator, 0,
+		"manages messages that are periodically sent as long as people are "+
+			"chatting (Usage: !timer add name interval minlines text, "+
+			"!timer remove/enable/disable name, !timer list)",
+		func(c *CommandData) {
+			ch := c.Channel
+			usage := "Usage: !timer add name interval minlines text, " +
+				"!timer remove/enable/disable name, !timer list"
+			if len(c.Args) < 1 {
+				ch.Privmsgf(usage)
+				return
+			}
+
+			var err error
+			switch {
+			case c.Args[0] == "list" && len(c.Args) == 1:
+				list := ch.TimerList()
+				if len(list) == 0 {
+					list = "none"
+				}
+				ch.Privmsgf("Timers: %s", list)
+				return
+
+			case c.Args[0] == "add" && len(c.Args) >= 5:
+				name := c.Args[1]
+				interval, perr := time.ParseDuration(c.Args[2])
+				minLines, aerr := strconv.Atoi(c.Args[3])
+				if perr != nil || aerr != nil {
+					ch.Privmsgf("Usage: !timer add name interval minlines " +
+						"text (for example !timer add socials 15m 10 " +
+						"follow me on twitter!)")
+					return
+				}
+
+				t := Timer{
+					Text:     strings.Join(c.Args[4:], " "),
+					Interval: interval,
+					MinLines: minLines,
+					Enabled:  true,
+				}
+				old, replaced := ch.Timer(name)
+				err = ch.SetTimer(name, t)
+				if err == nil {
+					before := ""
+					if replaced {
+						before = old.String()
+					}
+					ch.Audit(c.Nick, "timer add", name, before, t.String())
+					ch.Privmsgf("Added timer %s", name)
+				}
+
+			case c.Args[0] == "remove" && len(c.Args) == 2:
+				before, _ := ch.Timer(c.Args[1])
+				err = ch.RemoveTimer(c.Args[1])
+				if err == nil {
+					ch.Audit(c.Nick, "timer remove", c.Args[1],
+						before.String(), "")
+					ch.Privmsgf("Removed timer %s", c.Args[1])
+				}
 
-			req.Header.Add("Accept", "application/vnd.twitchtv.v3+json")
+			case c.Args[0] == "enable" && len(c.Args) == 2:
+				before, _ := ch.Timer(c.Args[1])
+				err = ch.EnableTimer(c.Args[1], true)
+				if err == nil {
+					after := before
+					after.Enabled = true
+					ch.Audit(c.Nick, "timer enable", c.Args[1],
+						before.String(), after.String())
+					ch.Privmsgf("Enabled timer %s", c.Args[1])
+				}
+
+			case c.Args[0] == "disable" && len(c.Args) == 2:
+				before, _ := ch.Timer(c.Args[1])
+				err = ch.EnableTimer(c.Args[1], false)
+				if err == nil {
+					after := before
+					after.Enabled = false
+					ch.Audit(c.Nick, "timer disable", c.Args[1],
+						before.String(), after.String())
+					ch.Privmsgf("Disabled timer %s", c.Args[1])
+				}
+
+			default:
+				ch.Privmsgf(usage)
+				return
+			}
 
-			client := &http.Client{}
-			resp, err := client.Do(req)
 			if err != nil {
-				ch.Privmsgf("API error: %v", err)
+				ch.Privmsgf("%v", err)
+			}
+		}))
+
+	b.Register(NewCommand("trigger", PermModerator, 0,
+		"replies with a text command when a message contains a keyword or "+
+			"matches a regular expression (Usage: !trigger add name "+
+			"commandname keyword/regex pattern, !trigger remove name, "+
+			"!trigger perm name level, !trigger cooldown name 30s, "+
+			"!trigger list)",
+		func(c *CommandData) {
+			ch := c.Channel
+			usage := "Usage: !trigger add name commandname keyword/regex " +
+				"pattern, !trigger remove name, !trigger perm name level, " +
+				"!trigger cooldown name 30s, !trigger list"
+			if len(c.Args) < 1 {
+				ch.Privmsgf(usage)
 				return
 			}
 
-			defer resp.Body.Close()
+			var err error
+			switch {
+			case c.Args[0] == "list" && len(c.Args) == 1:
+				list := ch.TriggerList()
+				if len(list) == 0 {
+					list = "none"
+				}
+				ch.Privmsgf("Triggers: %s", list)
+
+			case c.Args[0] == "add" && len(c.Args) >= 5 &&
+				(c.Args[3] == "keyword" || c.Args[3] == "regex"):
+
+				name := c.Args[1]
+				commandName := parseCommandName(c.Args[2])
+				if !b.caseSensitive {
+					commandName = strings.ToLower(commandName)
+				}
+				command := ch.lookupCommand(commandName)
+				if command == nil {
+					ch.Privmsgf("Command %s doesn't exist.", commandName)
+					return
+				}
+
+				t := Trigger{
+					Pattern: strings.Join(c.Args[4:], " "),
+					Regex:   c.Args[3] == "regex",
+					Command: commandName,
+					// so a busy chat doesn't make the bot spam
+					Cooldown: time.Second * 30,
+					Perm:     command.Permission(),
+				}
+				err = ch.SetTrigger(name, t)
+				if err == nil {
+					ch.Audit(c.Nick, "trigger add", name, "",
+						fmt.Sprintf("%s %s -> !%s", c.Args[3], t.Pattern,
+							commandName))
+					ch.Privmsgf("Added trigger %s", name)
+				}
+
+			case c.Args[0] == "remove" && len(c.Args) == 2:
+				before, _ := ch.Trigger(c.Args[1])
+				err = ch.RemoveTrigger(c.Args[1])
+				if err == nil {
+					ch.Audit(c.Nick, "trigger remove", c.Args[1],
+						fmt.Sprintf("%s -> !%s", before.Pattern,
+							before.Command), "")
+					ch.Privmsgf("Removed trigger %s", c.Args[1])
+				}
+
+			case c.Args[0] == "perm" && len(c.Args) == 3:
+				t, ok := ch.Trigger(c.Args[1])
+				if !ok {
+					ch.Privmsgf("Trigger %s doesn't exist.", c.Args[1])
+					return
+				}
+				before := t.Perm
+				t.Perm, err = ParsePermission(c.Args[2])
+				if err != nil {
+					ch.Privmsgf("%v", err)
+					return
+				}
+
+				// same as !cmdperm, nobody can lock a trigger above their
+				// own level
+				if !ch.HasPermission(c.User, t.Perm) {
+					ch.Privmsgf("You can't restrict triggers to %s.", t.Perm)
+					return
+				}
 
-			var res map[string]interface{}
+				err = ch.SetTrigger(c.Args[1], t)
+				if err == nil {
+					ch.Audit(c.Nick, "trigger perm", c.Args[1],
+						before.String(), t.Perm.String())
+					ch.Privmsgf("Trigger %s can now be fired by %s.",
+						c.Args[1], t.Perm)
+				}
+
+			case c.Args[0] == "cooldown" && len(c.Args) == 3:
+				t, ok := ch.Trigger(c.Args[1])
+				if !ok {
+					ch.Privmsgf("Trigger %s doesn't exist.", c.Args[1])
+					return
+				}
+				before := t.Cooldown
+				t.Cooldown, err = time.ParseDuration(c.Args[2])
+				if err == nil && t.Cooldown < 0 {
+					err = fmt.Errorf("Cooldowns can't be negative.")
+				}
+				if err == nil {
+					err = ch.SetTrigger(c.Args[1], t)
+				}
+				if err == nil {
+					ch.Audit(c.Nick, "trigger cooldown", c.Args[1],
+						before.String(), t.Cooldown.String())
+					ch.Privmsgf("Trigger %s cooldown set to %v.", c.Args[1],
+						t.Cooldown)
+				}
+
+			default:
+				ch.Privmsgf(usage)
+				return
+			}
 
-			err = json.NewDecoder(resp.Body).Decode(&res)
 			if err != nil {
-				ch.Privmsgf("API error: %v", err)
+				ch.Privmsgf("%v", err)
+			}
+		}))
+
+	b.Register(NewCommand("filter", PermModerator, 0,
+		"configures the spam filters: links, caps, symbols, repeat, length "+
+			"and emotes (Usage: !filter list, !filter name on/off, !filter "+
+			"name limit 70, !filter name exempt level, !filter whitelist "+
+			"add/remove domain, !filter whitelist list)",
+		func(c *CommandData) {
+			ch := c.Channel
+			usage := "Usage: !filter list, !filter name on/off, " +
+				"!filter name limit number, !filter name exempt level, " +
+				"!filter whitelist add/remove domain, !filter whitelist list"
+			if len(c.Args) < 1 {
+				ch.Privmsgf(usage)
 				return
 			}
 
-			// lol too lazy to do proper json decoding with structs
-			stream, ok := res["stream"].(map[string]interface{})
-			createdAt, ok := stream["created_at"].(string)
-			if len(createdAt) == 0 || !ok {
-				ch.Privmsgf("Offline")
+			var err error
+			switch {
+			case c.Args[0] == "list" && len(c.Args) == 1:
+				ch.Privmsgf("Filters: %s", ch.FilterList())
+
+			case c.Args[0] == "whitelist" && len(c.Args) == 2 &&
+				c.Args[1] == "list":
+
+				list := strings.Join(ch.Whitelist(), ", ")
+				if len(list) == 0 {
+					list = "none"
+				}
+				ch.Privmsgf("Whitelisted domains: %s", list)
+
+			case c.Args[0] == "whitelist" && len(c.Args) == 3 &&
+				c.Args[1] == "add":
+
+				err = ch.AddWhitelist(c.Args[2])
+				if err == nil {
+					ch.Audit(c.Nick, "filter whitelist add",
+						normalizeDomain(c.Args[2]), "", "")
+					ch.Privmsgf("Links to %s are now allowed.", c.Args[2])
+				}
+
+			case c.Args[0] == "whitelist" && len(c.Args) == 3 &&
+				c.Args[1] == "remove":
+
+				err = ch.RemoveWhitelist(c.Args[2])
+				if err == nil {
+					ch.Audit(c.Nick, "filter whitelist remove",
+						normalizeDomain(c.Args[2]), "", "")
+					ch.Privmsgf("Removed %s from the whitelist.", c.Args[2])
+				}
+
+			case len(c.Args) == 2 && (c.Args[1] == "on" || c.Args[1] == "off"):
+				var r FilterRule
+				r, err = ch.FilterRule(c.Args[0])
+				before := r
+				if err == nil {
+					r.Enabled = c.Args[1] == "on"
+					err = ch.SetFilterRule(c.Args[0], r)
+				}
+				if err == nil {
+					ch.Audit(c.Nick, "filter", c.Args[0], before.String(),
+						r.String())
+					ch.Privmsgf("Filter %s is now %s.", c.Args[0], c.Args[1])
+				}
+
+			case len(c.Args) == 3 && c.Args[1] == "limit":
+				var r FilterRule
+				r, err = ch.FilterRule(c.Args[0])
+				before := r
+				if err == nil {
+					r.Limit, err = strconv.Atoi(c.Args[2])
+					if err != nil {
+						err = fmt.Errorf("Usage: !filter name limit number")
+					}
+				}
+				if err == nil {
+					err = ch.SetFilterRule(c.Args[0], r)
+				}
+				if err == nil {
+					ch.Audit(c.Nick, "filter", c.Args[0], before.String(),
+						r.String())
+					ch.Privmsgf("Filter %s limit set to %d.", c.Args[0],
+						r.Limit)
+				}
+
+			case len(c.Args) == 3 && c.Args[1] == "exempt":
+				var r FilterRule
+				r, err = ch.FilterRule(c.Args[0])
+				before := r
+				if err == nil {
+					r.Exempt, err = ParsePermission(c.Args[2])
+				}
+				if err == nil {
+					err = ch.SetFilterRule(c.Args[0], r)
+				}
+				if err == nil {
+					ch.Audit(c.Nick, "filter", c.Args[0], before.String(),
+						r.String())
+					ch.Privmsgf("Filter %s now ignores %s and above.",
+						c.Args[0], r.Exempt)
+				}
+
+			default:
+				ch.Privmsgf(usage)
 				return
 			}
 
-			parsedTime, err := time.Parse(
-				"2006-01-02T15:04:05Z", createdAt)
 			if err != nil {
-				ch.Privmsgf("API error: %v", err)
+				ch.Privmsgf("%v", err)
+			}
+		}))
+
+	b.Register(NewCommand("permit", PermModerator, 0,
+		"lets a user post one link within the given number of seconds, "+
+			"60 by default (Usage: !permit user [seconds])",
+		func(c *CommandData) {
+			ch := c.Channel
+			usage := "Usage: !permit user [seconds]"
+			if len(c.Args) < 1 || len(c.Args) > 2 {
+				ch.Privmsgf(usage)
+				return
+			}
+
+			duration := DefaultPermitDuration
+			if len(c.Args) == 2 {
+				seconds, err := strconv.Atoi(c.Args[1])
+				if err != nil {
+					ch.Privmsgf(usage)
+					return
+				}
+				duration = time.Duration(seconds) * time.Second
+			}
+
+			nick := strings.TrimPrefix(c.Args[0], "@")
+			if err := ch.Permit(nick, duration); err != nil {
+				ch.Privmsgf("%v", err)
 				return
 			}
 
-			ch.Privmsgf("%v", time.Now().UTC().Sub(parsedTime))
-		},
-	}
+			ch.Audit(c.Nick, "permit", nick, "", duration.String())
+			ch.Privmsgf("%s may post a link within the next %v.", nick,
+				duration)
+		}))
+
+	b.Register(NewCommand("strikes", PermModerator, 0,
+		"shows or resets the strikes users get from the spam filters, or "+
+			"changes how long they last (Usage: !strikes user, !strikes "+
+			"reset user, !strikes decay 1h)",
+		func(c *CommandData) {
+			ch := c.Channel
+			usage := "Usage: !strikes user, !strikes reset user, " +
+				"!strikes decay 1h"
+
+			var err error
+			switch {
+			case len(c.Args) == 1:
+				var strikes int
+				strikes, err = ch.Strikes(c.Args[0])
+				if err == nil {
+					ch.Privmsgf("%s has %d strike(s), next punishment: %s.",
+						c.Args[0], strikes, punishmentFor(strikes+1))
+				}
+
+			case len(c.Args) == 2 && c.Args[0] == "reset":
+				var before int
+				before, err = ch.Strikes(c.Args[1])
+				if err == nil {
+					err = ch.ResetStrikes(c.Args[1])
+				}
+				if err == nil {
+					ch.Audit(c.Nick, "strikes reset", c.Args[1],
+						strconv.Itoa(before), "0")
+					ch.Privmsgf("Reset strikes for %s.", c.Args[1])
+				}
+
+			case len(c.Args) == 2 && c.Args[0] == "decay":
+				var decay time.Duration
+				decay, err = time.ParseDuration(c.Args[1])
+				if err != nil {
+					err = fmt.Errorf("%s", usage)
+				}
+				var before time.Duration
+				if err == nil {
+					before, err = ch.StrikeDecay()
+				}
+				if err == nil {
+					err = ch.SetStrikeDecay(decay)
+				}
+				if err == nil {
+					ch.Audit(c.Nick, "strikes decay", "channel",
+						before.String(), decay.String())
+					ch.Privmsgf("Strikes now expire after %v.", decay)
+				}
 
-	b.BuiltinCommandsInfo = "" +
-		`* +!cmdadd: adds a command (Usage: !cmdadd commandname text)
-* +!cmdremove: removes a command (Usage: !cmdremove commandname)
-* +!cmdedit: changes the text for a command (Usage: !cmdedit commandname text)
-* +!modonly: limits a command to mods only (Usage: !modonly commandname yes/no)
-* +!cooldown: milliseconds before a command can be reused (Usage: !cooldown ms)
-* !uptime: shows the channel's uptime if online`
+			default:
+				ch.Privmsgf(usage)
+				return
+			}
+
+			if err != nil {
+				ch.Privmsgf("%v", err)
+			}
+		}))
+
+	b.Register(NewCommand("blacklist", PermModerator, 0,
+		"bans words, phrases or regular expressions from chat, each with "+
+			"its own action (Usage: !blacklist add word/phrase/regex "+
+			"delete/ban pattern, !blacklist add word/phrase/regex timeout "+
+			"seconds pattern, !blacklist remove pattern, !blacklist list)",
+		func(c *CommandData) {
+			ch := c.Channel
+			usage := "Usage: !blacklist add word/phrase/regex delete/ban " +
+				"pattern, !blacklist add word/phrase/regex timeout seconds " +
+				"pattern, !blacklist remove pattern, !blacklist list"
+			if len(c.Args) < 1 {
+				ch.Privmsgf(usage)
+				return
+			}
+
+			var err error
+			switch {
+			case c.Args[0] == "list" && len(c.Args) == 1:
+				// the patterns themselves are only published to mods
+				url, perr := b.updateModList(ch)
+				if perr != nil {
+					ch.Println("Failed to publish the blacklist:", perr)
+					err = fmt.Errorf("Failed to publish the blacklist.")
+					break
+				}
+				ch.Whisperf(c.Nick, "Blacklist for %s: %s", ch.name[1:], url)
+				ch.Privmsgf("The blacklist has %d entries, the link was "+
+					"whispered to %s.", len(ch.Blacklist()), c.Nick)
+
+			case c.Args[0] == "add" && len(c.Args) >= 4:
+				e := BlacklistEntry{
+					Kind:   BlacklistKind(c.Args[1]),
+					Action: BlacklistAction(c.Args[2]),
+				}
+				pattern := c.Args[3:]
+				if e.Action == BlacklistTimeout {
+					seconds, aerr := strconv.Atoi(c.Args[3])
+					if aerr != nil || len(c.Args) < 5 {
+						ch.Privmsgf(usage)
+						return
+					}
+					e.Timeout = time.Duration(seconds) * time.Second
+					pattern = c.Args[4:]
+				}
+				e.Pattern = strings.Join(pattern, " ")
+
+				if e.Kind == BlacklistWord && len(pattern) != 1 {
+					err = fmt.Errorf("Words can't contain spaces, " +
+						"use a phrase instead.")
+				} else {
+					err = ch.AddBlacklist(e)
+				}
+				if err == nil {
+					ch.Audit(c.Nick, "blacklist add", "blacklist", "",
+						e.String())
+					ch.Privmsgf("Added blacklist entry: %s. There are now "+
+						"%d.", e.String(), len(ch.Blacklist()))
+				}
+
+			case c.Args[0] == "remove" && len(c.Args) >= 2:
+				pattern := strings.Join(c.Args[1:], " ")
+				var before string
+				for _, e := range ch.Blacklist() {
+					if e.Pattern == pattern {
+						before = e.String()
+					}
+				}
+				err = ch.RemoveBlacklist(pattern)
+				if err == nil {
+					ch.Audit(c.Nick, "blacklist remove", "blacklist", before,
+						"")
+					ch.Privmsgf("Removed a blacklist entry, %d left.",
+						len(ch.Blacklist()))
+				}
+
+			default:
+				ch.Privmsgf(usage)
+				return
+			}
+
+			if err != nil {
+				ch.Privmsgf("%v", err)
+			}
+		}))
+
+	b.Register(NewCommand("modlog", PermModerator, 0,
+		fmt.Sprintf("shows the last moderation actions, %d by default, or "+
+			"whispers a link to the full log (Usage: !modlog [number], "+
+			"!modlog link)", defaultModlogEntries),
+		func(c *CommandData) {
+			ch := c.Channel
+			usage := fmt.Sprintf("Usage: !modlog [1-%d], !modlog link",
+				maxModlogEntries)
+
+			if len(c.Args) == 1 && c.Args[0] == "link" {
+				url, err := b.updateModList(ch)
+				if err != nil {
+					ch.Println("Failed to publish the moderation log:", err)
+					ch.Privmsgf("Failed to publish the moderation log.")
+					return
+				}
+				ch.Whisperf(c.Nick, "Moderation log for %s: %s", ch.name[1:],
+					url)
+				return
+			}
+
+			n := defaultModlogEntries
+			if len(c.Args) == 1 {
+				var err error
+				n, err = strconv.Atoi(c.Args[0])
+				if err != nil || n < 1 || n > maxModlogEntries {
+					ch.Privmsgf(usage)
+					return
+				}
+			} else if len(c.Args) > 1 {
+				ch.Privmsgf(usage)
+				return
+			}
+
+			entries, err := ch.AuditLog(n)
+			if err != nil {
+				ch.Privmsgf("%v", err)
+				return
+			}
+
+			if len(entries) == 0 {
+				ch.Privmsgf("The moderation log is empty.")
+				return
+			}
+
+			lines := make([]string, len(entries))
+			for i, e := range entries {
+				lines[i] = e.String()
+			}
+			ch.Privmsgf("%s", strings.Join(lines, " | "))
+		}))
+
+	b.Register(NewCommand("quote", PermEveryone, 0,
+		"shows a random quote, or a specific one (Usage: !quote, !quote 42, "+
+			"!quote search word, !quote add text, mods only: !quote del 42)",
+		func(c *CommandData) {
+			ch := c.Channel
+
+			if len(c.Args) == 0 {
+				q, err := ch.RandomQuote()
+				if err != nil {
+					ch.Privmsgf("%v", err)
+					return
+				}
+				ch.Privmsgf("%s", q)
+				return
+			}
+
+			switch c.Args[0] {
+			case "add":
+				if len(c.Args) < 2 {
+					ch.Privmsgf("Usage: !quote add text")
+					return
+				}
+
+				q, err := ch.AddQuote(strings.Join(c.Args[1:], " "), c.Nick)
+				if err != nil {
+					ch.Privmsgf("%v", err)
+					return
+				}
+
+				ch.Privmsgf("Added quote #%d", q.Number)
+				b.updateCommandList(ch)
+
+			case "del":
+				if !ch.HasPermission(c.User, PermModerator) {
+					return
+				}
+
+				if len(c.Args) != 2 {
+					ch.Privmsgf("Usage: !quote del number")
+					return
+				}
+
+				n, err := strconv.Atoi(c.Args[1])
+				if err != nil {
+					ch.Privmsgf("Usage: !quote del number")
+					return
+				}
+
+				q, err := ch.Quote(n)
+				if err == nil {
+					err = ch.RemoveQuote(n)
+				}
+				if err != nil {
+					ch.Privmsgf("%v", err)
+					return
+				}
+
+				ch.Audit(c.Nick, "quote del", fmt.Sprintf("#%d", n), q.Text, "")
+				ch.Privmsgf("Removed quote #%d", n)
+				b.updateCommandList(ch)
+
+			case "search":
+				if len(c.Args) < 2 {
+					ch.Privmsgf("Usage: !quote search text")
+					return
+				}
+
+				found, err := ch.SearchQuotes(strings.Join(c.Args[1:], " "))
+				if err != nil {
+					ch.Privmsgf("%v", err)
+					return
+				}
+
+				switch len(found) {
+				case 0:
+					ch.Privmsgf("No quotes found.")
+				case 1:
+					ch.Privmsgf("%s", found[0])
+				default:
+					numbers := make([]string, len(found))
+					for i, q := range found {
+						numbers[i] = "#" + strconv.Itoa(q.Number)
+					}
+					ch.Privmsgf("Found %d quotes: %s", len(found),
+						strings.Join(numbers, ", "))
+				}
+
+			default:
+				n, err := strconv.Atoi(c.Args[0])
+				if err != nil {
+					ch.Privmsgf("Usage: !quote, !quote number, " +
+						"!quote search text, !quote add text")
+					return
+				}
+
+				q, err := ch.Quote(n)
+				if err != nil {
+					ch.Privmsgf("%v", err)
+					return
+				}
+				ch.Privmsgf("%s", q)
+			}
+		}))
+
+	b.Register(NewCommand("uptime", PermEveryone, 0,
+		"shows the channel's uptime if online",
+		func(c *CommandData) {
+			ch := c.Channel
+
+			uptime, online, err := ch.Uptime()
+			switch {
+			case err != nil:
+				ch.Privmsgf("Couldn't get the uptime: %v", err)
+			case !online:
+				ch.Privmsgf("Offline")
+			default:
+				ch.Privmsgf("%v", uptime)
+			}
+		}))
 
-	fmt.Println("> Built-in commands initialized")
+	b.log.Println("> Built-in commands initialized")
 }
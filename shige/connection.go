@@ -0,0 +1,183 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"fmt"
+	"github.com/thoj/go-ircevent"
+	"math/rand"
+	"time"
+)
+
+// A ConnState is the state of the bot's connection to twitch irc.
+type ConnState int
+
+const (
+	StateDisconnected ConnState = iota
+	StateConnecting
+	StateConnected
+	StateReconnecting
+)
+
+var connStateNames = []string{
+	"disconnected",
+	"connecting",
+	"connected",
+	"reconnecting",
+}
+
+func (s ConnState) String() string {
+	if s < 0 || int(s) >= len(connStateNames) {
+		return fmt.Sprintf("ConnState(%d)", int(s))
+	}
+	return connStateNames[s]
+}
+
+const (
+	// how often the irc library pings the server
+	pingFrequency = time.Minute
+	// how long to wait for any message, including PONGs, before considering
+	// the connection dead
+	readTimeout = time.Minute * 4
+
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute * 5
+)
+
+// reconnectDelay returns how long to wait before the attempt-th reconnection
+// attempt in a row. The delay doubles with each attempt up to
+// maxReconnectDelay, and a random jitter of up to half the delay is applied so
+// many bots don't all reconnect at the same time after an outage.
+func reconnectDelay(attempt int) time.Duration {
+	delay := maxReconnectDelay
+	if attempt < 16 {
+		delay = minReconnectDelay << uint(attempt)
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// State returns the current state of the connection to twitch irc.
+func (b *Bot) State() ConnState {
+	resp := make(chan ConnState, 1)
+	b.w.Do(func() {
+		resp <- b.state
+		close(resp)
+	})
+	return <-resp
+}
+
+// changes the connection state and notifies OnStateChange. err is the reason
+// for the change, if any.
+func (b *Bot) setState(state ConnState, err error) {
+	changed := false
+	b.w.Await(func() {
+		changed = b.state != state
+		b.state = state
+		if state == StateConnected {
+			b.reconnectAttempts = 0
+		}
+	})
+
+	if !changed {
+		return
+	}
+
+	if err != nil {
+		b.log.Println("> Connection", state, err)
+	} else {
+		b.log.Println("> Connection", state)
+	}
+
+	if b.OnStateChange != nil {
+		b.OnStateChange(state, err)
+	}
+}
+
+// Quit disconnects from twitch irc and makes Run return.
+func (b *Bot) Quit() {
+	b.w.Await(b.setQuitting)
+	b.irc.Quit()
+}
+
+// marks the bot as quitting and wakes up supervise if it's waiting to
+// reconnect. Must run on the worker.
+func (b *Bot) setQuitting() {
+	if !b.quitting {
+		b.quitting = true
+		close(b.quit)
+	}
+}
+
+func (b *Bot) isQuitting() bool {
+	resp := make(chan bool, 1)
+	b.w.Do(func() {
+		resp <- b.quitting
+		close(resp)
+	})
+	return <-resp
+}
+
+// supervise waits for the connection to die and reconnects with exponential
+// backoff until Quit is called. Capabilities are requested again and every
+// channel is rejoined by the 001 callback once the server accepts us.
+func (b *Bot) supervise() {
+	for {
+		err := <-b.irc.ErrorChan()
+
+		// same teardown as the irc library's Loop: stop the old connection's
+		// goroutines and close its socket before dialing again. Disconnect
+		// already did that if it's what killed the connection.
+		if err != irc.ErrDisconnected {
+			b.irc.Disconnect()
+		}
+
+		if b.isQuitting() {
+			b.setState(StateDisconnected, nil)
+			return
+		}
+
+		b.setState(StateDisconnected, err)
+
+		for {
+			attempt := 0
+			b.w.Await(func() {
+				attempt = b.reconnectAttempts
+				b.reconnectAttempts++
+			})
+
+			delay := reconnectDelay(attempt)
+			b.setState(StateReconnecting, err)
+			b.log.Println("> Reconnecting in", delay)
+
+			select {
+			case <-time.After(delay):
+			case <-b.quit:
+				b.setState(StateDisconnected, nil)
+				return
+			}
+
+			b.setState(StateConnecting, nil)
+			err = b.irc.Reconnect()
+			if err == nil {
+				break
+			}
+			b.log.Println("> Failed to reconnect:", err)
+		}
+	}
+}
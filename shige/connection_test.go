@@ -0,0 +1,51 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{0, time.Millisecond * 500, time.Second},
+		{1, time.Second, time.Second * 2},
+		{2, time.Second * 2, time.Second * 4},
+		{5, time.Second * 16, time.Second * 32},
+		{8, time.Second * 128, time.Second * 256},
+		{9, maxReconnectDelay / 2, maxReconnectDelay},
+		{15, maxReconnectDelay / 2, maxReconnectDelay},
+		{16, maxReconnectDelay / 2, maxReconnectDelay},
+		{64, maxReconnectDelay / 2, maxReconnectDelay},
+		{1000, maxReconnectDelay / 2, maxReconnectDelay},
+	}
+
+	for _, test := range tests {
+		// the jitter is random, so try a few times
+		for i := 0; i < 100; i++ {
+			delay := reconnectDelay(test.attempt)
+			if delay < test.min || delay > test.max {
+				t.Errorf("reconnectDelay(%d) = %v, want between %v and %v",
+					test.attempt, delay, test.min, test.max)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,228 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A counter is a named number saved per channel, such as a death counter.
+// A counter called deaths can be shown with !deaths, while mods can change it
+// with !deaths+, !deaths- and !deaths set n. Text commands can show it with
+// $(counter deaths).
+
+// counterCommand exposes a counter as a Command. op is '+' or '-' for the
+// increment and decrement commands and 0 for the command that shows it.
+type counterCommand struct {
+	channel *Channel
+	name    string
+	op      byte
+}
+
+func (cc *counterCommand) Name() string {
+	if cc.op == 0 {
+		return cc.name
+	}
+	return cc.name + string(cc.op)
+}
+
+func (cc *counterCommand) Aliases() []string { return nil }
+
+func (cc *counterCommand) Permission() Permission {
+	if cc.op == 0 {
+		return PermEveryone
+	}
+	return PermModerator
+}
+
+func (cc *counterCommand) Cooldown() time.Duration { return 0 }
+
+func (cc *counterCommand) Help() string {
+	return fmt.Sprintf("shows the %s counter (mods can use !%s+, !%s- and "+
+		"!%s set n)", cc.name, cc.name, cc.name, cc.name)
+}
+
+// mods shouldn't have to wait for the cooldown to count several deaths in a
+// row.
+func (cc *counterCommand) PerUserCooldown() time.Duration { return 0 }
+func (cc *counterCommand) ExemptsMods() bool              { return true }
+
+func (cc *counterCommand) Run(c *CommandData) {
+	ch := cc.channel
+
+	var value int
+	var err error
+	switch {
+	case cc.op == '+':
+		value, err = ch.AddToCounter(cc.name, 1)
+
+	case cc.op == '-':
+		value, err = ch.AddToCounter(cc.name, -1)
+
+	case len(c.Args) == 2 && c.Args[0] == "set":
+		if !ch.HasPermission(c.User, PermModerator) {
+			ch.Println("Rejected counter set because the user is not",
+				PermModerator)
+			return
+		}
+
+		value, err = strconv.Atoi(c.Args[1])
+		if err != nil {
+			ch.Privmsgf("Usage: !%s set number", cc.name)
+			return
+		}
+		before, _ := ch.Counter(cc.name)
+		err = ch.SetCounter(cc.name, value)
+		if err == nil {
+			ch.Audit(c.Nick, "counter set", cc.name, strconv.Itoa(before),
+				strconv.Itoa(value))
+		}
+
+	default:
+		value, _ = ch.Counter(cc.name)
+	}
+
+	if err != nil {
+		ch.Privmsgf("%v", err)
+		return
+	}
+
+	ch.Privmsgf("%s: %d", cc.name, value)
+}
+
+// parses a command name such as deaths+ into the counter name and operation.
+func parseCounterCommand(cmd string) (name string, op byte) {
+	name = cmd
+	if strings.HasSuffix(cmd, "+") || strings.HasSuffix(cmd, "-") {
+		name = cmd[:len(cmd)-1]
+		op = cmd[len(cmd)-1]
+	}
+	return
+}
+
+// counterCommand returns the Command for a counter command such as deaths,
+// deaths+ or deaths-, or nil if the counter doesn't exist.
+func (c *Channel) counterCommand(cmd string) Command {
+	name, op := parseCounterCommand(cmd)
+	if !c.CounterExists(name) {
+		return nil
+	}
+	return &counterCommand{c, name, op}
+}
+
+// CounterExists returns whether a counter exists.
+func (c *Channel) CounterExists(name string) bool {
+	_, ok := c.Counter(name)
+	return ok
+}
+
+// Counter returns the current value of a counter. ok is false if the counter
+// doesn't exist.
+func (c *Channel) Counter(name string) (value int, ok bool) {
+	type result struct {
+		value int
+		ok    bool
+	}
+	resp := make(chan result, 1)
+	c.parent.w.Do(func() {
+		value, ok := c.counters[name]
+		resp <- result{value, ok}
+		close(resp)
+	})
+	res := <-resp
+	return res.value, res.ok
+}
+
+// AddCounter creates a counter starting at zero.
+func (c *Channel) AddCounter(name string) error {
+	if c.CounterExists(name) || c.CommandExists(name) ||
+		c.ResolveAlias(name) != name || c.parent.CommandExists(name) {
+
+		return fmt.Errorf("Command %s already exists.", name)
+	}
+
+	if _, op := parseCounterCommand(name); op != 0 {
+		return fmt.Errorf("Counter names can't end with + or -.")
+	}
+
+	return c.SetCounter(name, 0)
+}
+
+// RemoveCounter removes a counter.
+func (c *Channel) RemoveCounter(name string) (err error) {
+	c.parent.counterWorker.Await(func() {
+		if !c.CounterExists(name) {
+			err = fmt.Errorf("Counter %s doesn't exist.", name)
+			return
+		}
+
+		err = c.parent.attemptQuery(func() error {
+			return c.parent.db.RemoveCounter(c.name, name)
+		})
+		if err != nil {
+			return
+		}
+
+		c.parent.w.Await(func() { delete(c.counters, name) })
+	})
+	if err != nil {
+		return
+	}
+
+	c.Println("Removed counter", name)
+	return
+}
+
+// SetCounter sets the value of a counter, creating it if it doesn't exist.
+func (c *Channel) SetCounter(name string, value int) (err error) {
+	c.parent.counterWorker.Await(func() {
+		err = c.updateCounter(name, value)
+	})
+	return
+}
+
+// AddToCounter adds delta to a counter and returns the new value.
+// Counter changes are serialized, so concurrent changes are never lost.
+func (c *Channel) AddToCounter(name string, delta int) (value int, err error) {
+	c.parent.counterWorker.Await(func() {
+		current, ok := c.Counter(name)
+		if !ok {
+			err = fmt.Errorf("Counter %s doesn't exist.", name)
+			return
+		}
+		value = current + delta
+		err = c.updateCounter(name, value)
+	})
+	return
+}
+
+// saves and sets a counter. Must run on the counter worker, which applies
+// changes in order without making the main worker wait for the database.
+func (c *Channel) updateCounter(name string, value int) error {
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.SetCounter(c.name, name, value)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.parent.w.Await(func() { c.counters[name] = value })
+	c.Println("Counter", name, "->", value)
+	return nil
+}
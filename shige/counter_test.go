@@ -0,0 +1,73 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAddToCounter(t *testing.T) {
+	c, _ := newTestChannel(t)
+	if err := c.AddCounter("deaths"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		deltas []int
+		want   int
+	}{
+		{[]int{1}, 1},
+		{[]int{1, 1, 1, -1}, 3},
+		{[]int{-5}, -2},
+		{make([]int, 50), -2},
+	}
+
+	for _, test := range tests {
+		// changes made at the same time must all be counted
+		var wg sync.WaitGroup
+		for _, delta := range test.deltas {
+			wg.Add(1)
+			go func(delta int) {
+				defer wg.Done()
+				if _, err := c.AddToCounter("deaths", delta); err != nil {
+					t.Error(err)
+				}
+			}(delta)
+		}
+		wg.Wait()
+
+		value, _ := c.Counter("deaths")
+		saved, err := c.parent.db.Counters(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != test.want || saved["deaths"] != test.want {
+			t.Errorf("after adding %v the counter is %d and %d is saved, "+
+				"want %d", test.deltas, value, saved["deaths"], test.want)
+		}
+	}
+
+	if _, err := c.AddToCounter("lives", 1); err == nil {
+		t.Error("changed a counter that doesn't exist")
+	}
+	if err := c.RemoveCounter("deaths"); err != nil {
+		t.Fatal(err)
+	}
+	if c.CounterExists("deaths") {
+		t.Error("removed counter still exists")
+	}
+}
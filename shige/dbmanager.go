@@ -17,18 +17,62 @@ package shige
 
 import (
 	"database/sql"
-	"fmt"
 	_ "github.com/cznic/ql/driver"
-	//_ "github.com/mattn/go-sqlite3"
-	"os"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"time"
 )
 
 const commandsFile = "shige_ql.db"
 
-type dbManager struct{ *sql.DB }
+// dbManager implements Storage on top of a sql database.
+type dbManager struct {
+	*sql.DB
+	log     *log.Logger
+	dialect dialect
+}
+
+// a flavour of sql supported by dbManager. The queries are written for ql
+// and work on sqlite as they are, except for column types.
+type dialect struct {
+	driver string
+	// column types by their name in ql
+	types map[string]string
+}
+
+var (
+	qlDialect     = dialect{"ql", nil}
+	sqliteDialect = dialect{"sqlite3", map[string]string{
+		"string": "text",
+		"int":    "integer",
+		"bool":   "boolean",
+	}}
+)
+
+var columnType = regexp.MustCompile(`\b(string|int|bool)( not null|;)`)
+
+// kind translates a ql column type.
+func (d dialect) kind(k string) string {
+	if t, ok := d.types[k]; ok {
+		return t
+	}
+	return k
+}
+
+// schema translates the column types in a ql schema.
+func (d dialect) schema(s string) string {
+	return columnType.ReplaceAllStringFunc(s, func(col string) string {
+		m := columnType.FindStringSubmatch(col)
+		return d.kind(m[1]) + m[2]
+	})
+}
 
 func (b *Bot) initDB() (err error) {
-	b.db, err = newDBManager()
+	if b.db != nil {
+		return
+	}
+	b.db, err = NewQLStorage(b.dbFile, b.log)
 	//b.db.convertDB()
 	return
 }
@@ -61,7 +105,7 @@ func (db dbManager) convertDB()  {
 		if err != nil {
 			panic(err)
 		}
-		db.setCommand(channel, name, reply, modOnly)
+		db.SetCommand(channel, name, reply, modOnly)
 	}
 
 	rows.Close()
@@ -84,7 +128,7 @@ func (db dbManager) convertDB()  {
 		if err != nil {
 			panic(err)
 		}
-		db.setGist(channel, url)
+		db.SetGist(channel, url)
 	}
 
 	rows.Close()
@@ -92,254 +136,724 @@ func (db dbManager) convertDB()  {
 }
 */
 
-func newDBManager() (db dbManager, err error) {
-	_, err = os.Stat(commandsFile)
-	createTables := os.IsNotExist(err)
+// NewQLStorage opens the ql database file at path, creating it if it doesn't
+// exist. This is the default storage. Queries are logged to logger if it's
+// not nil.
+func NewQLStorage(path string, logger *log.Logger) (Storage, error) {
+	return newDBManager(qlDialect, path, logger)
+}
 
-	conn, err := sql.Open("ql", commandsFile)
-	if err != nil {
-		return
-	}
+// NewSQLiteStorage opens the sqlite database file at path, creating it if it
+// doesn't exist. The sqlite driver needs cgo, so it's only available when
+// building with the sqlite tag. Queries are logged to logger if it's not nil.
+func NewSQLiteStorage(path string, logger *log.Logger) (Storage, error) {
+	return newDBManager(sqliteDialect, path, logger)
+}
 
-	db = dbManager{conn}
+// opens the database without touching the schema.
+func openDBManager(d dialect, path string, logger *log.Logger) (
+	db dbManager, err error) {
 
-	if !createTables {
-		return
+	if logger == nil {
+		logger = log.New(ioutil.Discard, "", 0)
 	}
 
-	err = nil
-
-	fmt.Println("DB: Initializing tables")
-	sqlStmt := `
-	create table commands (
-		channel string not null, 
-		name string not null, 
-		reply string not null, 
-		mod_only bool not null
-	);
-	create table gists (
-		channel string not null, 
-		url string not null
-	);
-	create unique index commands_index on commands(channel, name);
-	create unique index gists_index on gists(channel);`
-
-	tx, err := db.Begin()
+	conn, err := sql.Open(d.driver, path)
 	if err != nil {
-		panic(err)
+		return
 	}
-	defer tx.Commit()
 
-	_, err = tx.Exec(sqlStmt)
+	db = dbManager{conn, logger, d}
 	return
 }
 
-func (db dbManager) getGist(channel string) (gistUrl string) {
-	fmt.Println("DB: Getting gist for", channel)
-	sqlStmt, err := db.Prepare("select url from gists where channel==$1;")
+func newDBManager(d dialect, path string, logger *log.Logger) (
+	Storage, error) {
+
+	db, err := openDBManager(d, path, logger)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	defer sqlStmt.Close()
 
-	rows, err := sqlStmt.Query(channel)
+	err = db.migrate(false)
 	if err != nil {
-		panic(err)
+		db.Close()
+		return nil, err
 	}
 
-	defer rows.Close()
+	return db, nil
+}
 
-	if !rows.Next() {
-		return
+// inTx runs f inside a transaction, which is committed if f succeeds and
+// rolled back otherwise.
+func (db dbManager) inTx(f func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = f(tx); err != nil {
+		if rberr := tx.Rollback(); rberr != nil {
+			db.log.Println("DB: Rollback failed:", rberr)
+		}
+		return err
 	}
 
-	err = rows.Scan(&gistUrl)
+	return tx.Commit()
+}
+
+// query runs a select and calls scan for every row.
+func (db dbManager) query(scan func(rows *sql.Rows) error, query string,
+	args ...interface{}) error {
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer rows.Close()
 
-	return
+	for rows.Next() {
+		if err = scan(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
 }
 
-func (db dbManager) gistExists(channel string) bool {
-	return len(db.getGist(channel)) != 0
+func (db dbManager) Gist(channel string) (gistUrl string, err error) {
+	db.log.Println("DB: Getting gist for", channel)
+	err = db.query(func(rows *sql.Rows) error {
+		return rows.Scan(&gistUrl)
+	}, "select url from gists where channel==$1;", channel)
+	return
 }
 
-func (db dbManager) setGist(channel, gistUrl string) error {
-	justUpdate := db.gistExists(channel)
+func (db dbManager) SetGist(channel, gistUrl string) error {
+	db.log.Println("DB: Setting gist for", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from gists where channel==$1;", channel)
+		if err != nil {
+			return err
+		}
 
-	tx, err := db.Begin()
-	if err != nil {
-		panic(err)
-	}
-	defer tx.Commit()
+		_, err = tx.Exec("insert into gists(channel, url) values($1, $2);",
+			channel, gistUrl)
+		return err
+	})
+}
 
-	if justUpdate {
-		fmt.Println("DB: Updating gist for", channel)
-		sqlStmt, err := tx.Prepare("update gists set url=$1 where channel==$2;")
+func (db dbManager) ModGist(channel string) (gistUrl string, err error) {
+	db.log.Println("DB: Getting mod gist for", channel)
+	err = db.query(func(rows *sql.Rows) error {
+		return rows.Scan(&gistUrl)
+	}, "select url from mod_gists where channel==$1;", channel)
+	return
+}
+
+func (db dbManager) SetModGist(channel, gistUrl string) error {
+	db.log.Println("DB: Setting mod gist for", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from mod_gists where channel==$1;", channel)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		defer sqlStmt.Close()
 
-		_, err = sqlStmt.Exec(gistUrl, channel)
+		_, err = tx.Exec("insert into mod_gists(channel, url) values($1, $2);",
+			channel, gistUrl)
+		return err
+	})
+}
+
+func (db dbManager) Commands(channel string) (
+	res map[string]*TextCommand, err error) {
+
+	db.log.Println("DB: Loading commands for", channel)
+	res = make(map[string]*TextCommand)
+
+	err = db.query(func(rows *sql.Rows) error {
+		c := &TextCommand{}
+		var name string
+		var level, uses, globalCooldown, userCooldown int64
+		err := rows.Scan(&name, &c.Text, &level, &uses, &globalCooldown,
+			&userCooldown, &c.ModsExempt)
 		if err != nil {
 			return err
 		}
+		c.Perm = Permission(level)
+		c.Uses = int(uses)
+		c.GlobalCooldown = time.Duration(globalCooldown) * time.Millisecond
+		c.UserCooldown = time.Duration(userCooldown) * time.Millisecond
+		res[name] = c
 		return nil
-	}
+	}, "select name, reply, permission, uses, global_cooldown, "+
+		"user_cooldown, mods_exempt from commands where channel==$1;",
+		channel)
+	return
+}
 
-	fmt.Println("DB: Adding gist for", channel)
-	sqlStmt, err := tx.Prepare(
-		"insert into gists(channel, url) values($1, $2);")
-	if err != nil {
-		panic(err)
-	}
-	defer sqlStmt.Close()
+func (db dbManager) SetCommand(channel, command string, c *TextCommand) error {
+	globalCooldown := int64(c.GlobalCooldown / time.Millisecond)
+	userCooldown := int64(c.UserCooldown / time.Millisecond)
 
-	_, err = sqlStmt.Exec(channel, gistUrl)
-	if err != nil {
+	return db.inTx(func(tx *sql.Tx) error {
+		res, err := tx.Exec("update commands set reply=$1, mod_only=$2, "+
+			"permission=$3, global_cooldown=$4, user_cooldown=$5, "+
+			"mods_exempt=$6 where channel==$7 and name==$8;", c.Text,
+			c.Perm >= PermModerator, int64(c.Perm), globalCooldown,
+			userCooldown, c.ModsExempt, channel, command)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil || n != 0 {
+			db.log.Println("DB: Updated command", command, "for", channel)
+			return err
+		}
+
+		db.log.Println("DB: Adding command", command, "for", channel)
+		_, err = tx.Exec("insert into commands(channel, name, reply, "+
+			"mod_only, permission, uses, global_cooldown, user_cooldown, "+
+			"mods_exempt) values($1, $2, $3, $4, $5, $6, $7, $8, $9);",
+			channel, command, c.Text, c.Perm >= PermModerator, int64(c.Perm),
+			int64(c.Uses), globalCooldown, userCooldown, c.ModsExempt)
 		return err
-	}
+	})
+}
+
+func (db dbManager) RemoveCommand(channel, command string) error {
+	db.log.Println("DB: Removing command", command, "for", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from commands where channel==$1 and "+
+			"name==$2;", channel, command)
+		return err
+	})
+}
 
-	return nil
+func (db dbManager) SetCommandUses(channel, command string, uses int) error {
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("update commands set uses=$1 where channel==$2 "+
+			"and name==$3;", int64(uses), channel, command)
+		return err
+	})
 }
 
-func (db dbManager) getCommand(channel, command string) (
-	text string, modOnly bool) {
+// ChannelCooldown returns the default command cooldown of channel in
+// milliseconds.
+func (db dbManager) ChannelCooldown(channel string) (cooldown int32,
+	err error) {
 
-	fmt.Println("DB: Getting command", command, "in", channel)
-	sqlStmt, err := db.Prepare(
-		"select reply, mod_only from commands where channel==$1 and name==$2;")
-	if err != nil {
-		panic(err)
-	}
-	defer sqlStmt.Close()
+	db.log.Println("DB: Getting cooldown for", channel)
+	err = db.query(func(rows *sql.Rows) error {
+		var res int64
+		err := rows.Scan(&res)
+		cooldown = int32(res)
+		return err
+	}, "select cooldown from channels where channel==$1;", channel)
+	return
+}
 
-	rows, err := sqlStmt.Query(channel, command)
-	if err != nil {
-		panic(err)
-	}
+func (db dbManager) SetChannelCooldown(channel string, cooldown int32) error {
+	db.log.Println("DB: Setting cooldown for", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from channels where channel==$1;", channel)
+		if err != nil {
+			return err
+		}
 
-	defer rows.Close()
+		_, err = tx.Exec("insert into channels(channel, cooldown) "+
+			"values($1, $2);", channel, int64(cooldown))
+		return err
+	})
+}
 
-	if !rows.Next() {
+// Aliases returns a map of every alias in channel to the name of the
+// command it refers to.
+func (db dbManager) Aliases(channel string) (res map[string]string,
+	err error) {
+
+	db.log.Println("DB: Loading aliases for", channel)
+	res = make(map[string]string)
+	err = db.query(func(rows *sql.Rows) error {
+		var alias, command string
+		err := rows.Scan(&alias, &command)
+		res[alias] = command
+		return err
+	}, "select alias, command from aliases where channel==$1;", channel)
+	return
+}
+
+func (db dbManager) AddAlias(channel, alias, command string) error {
+	db.log.Println("DB: Adding alias", alias, "->", command, "for", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("insert into aliases(channel, alias, command) "+
+			"values($1, $2, $3);", channel, alias, command)
+		return err
+	})
+}
+
+// RemoveAlias removes alias, or every alias of command if alias is empty.
+func (db dbManager) RemoveAlias(channel, alias, command string) error {
+	return db.inTx(func(tx *sql.Tx) (err error) {
+		if len(alias) == 0 {
+			db.log.Println("DB: Removing aliases of", command, "for", channel)
+			_, err = tx.Exec("delete from aliases where channel==$1 and "+
+				"command==$2;", channel, command)
+		} else {
+			db.log.Println("DB: Removing alias", alias, "for", channel)
+			_, err = tx.Exec("delete from aliases where channel==$1 and "+
+				"alias==$2;", channel, alias)
+		}
 		return
-	}
+	})
+}
 
-	err = rows.Scan(&text, &modOnly)
-	if err != nil {
-		panic(err)
-	}
+func (db dbManager) Counters(channel string) (res map[string]int,
+	err error) {
 
+	db.log.Println("DB: Loading counters for", channel)
+	res = make(map[string]int)
+	err = db.query(func(rows *sql.Rows) error {
+		var name string
+		var value int64
+		err := rows.Scan(&name, &value)
+		res[name] = int(value)
+		return err
+	}, "select name, value from counters where channel==$1;", channel)
 	return
 }
 
-func (db dbManager) getCommands(channel string) (res map[string]*TextCommand) {
-	fmt.Println("DB: Loading commands for", channel)
-	res = make(map[string]*TextCommand)
+// SetCounter creates or updates a counter.
+func (db dbManager) SetCounter(channel, name string, value int) error {
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from counters where channel==$1 and "+
+			"name==$2;", channel, name)
+		if err != nil {
+			return err
+		}
 
-	sqlStmt, err := db.Prepare(
-		"select name, reply, mod_only from commands where channel==$1;")
-	if err != nil {
-		panic(err)
-	}
-	defer sqlStmt.Close()
+		_, err = tx.Exec("insert into counters(channel, name, value) "+
+			"values($1, $2, $3);", channel, name, int64(value))
+		return err
+	})
+}
 
-	rows, err := sqlStmt.Query(channel)
-	if err != nil {
-		panic(err)
-	}
+func (db dbManager) RemoveCounter(channel, name string) error {
+	db.log.Println("DB: Removing counter", name, "for", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from counters where channel==$1 and "+
+			"name==$2;", channel, name)
+		return err
+	})
+}
 
-	defer rows.Close()
+// Timers loads the timers for channel.
+func (db dbManager) Timers(channel string) (res map[string]*Timer,
+	err error) {
 
-	for rows.Next() {
-		c := &TextCommand{}
+	db.log.Println("DB: Loading timers for", channel)
+	res = make(map[string]*Timer)
+	err = db.query(func(rows *sql.Rows) error {
+		t := &Timer{}
 		var name string
-		err = rows.Scan(&name, &c.Text, &c.ModOnly)
+		var interval, minLines int64
+		err := rows.Scan(&name, &t.Text, &interval, &minLines, &t.Enabled)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		res[name] = c
-		fmt.Println(res[name])
-	}
-
+		t.Interval = time.Duration(interval) * time.Second
+		t.MinLines = int(minLines)
+		res[name] = t
+		return nil
+	}, "select name, reply, interval, min_lines, enabled from timers "+
+		"where channel==$1;", channel)
 	return
 }
 
-func (db dbManager) commandExists(channel, command string) bool {
-	text, _ := db.getCommand(channel, command)
-	return len(text) != 0
+// SetTimer creates or updates a timer.
+func (db dbManager) SetTimer(channel, name string, t *Timer) error {
+	db.log.Println("DB: Setting timer", name, "for", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from timers where channel==$1 and "+
+			"name==$2;", channel, name)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("insert into timers(channel, name, reply, "+
+			"interval, min_lines, enabled) values($1, $2, $3, $4, $5, $6);",
+			channel, name, t.Text, int64(t.Interval/time.Second),
+			int64(t.MinLines), t.Enabled)
+		return err
+	})
 }
 
-func (db dbManager) setCommand(channel, command,
-	text string, modOnly bool) error {
+func (db dbManager) RemoveTimer(channel, name string) error {
+	db.log.Println("DB: Removing timer", name, "for", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from timers where channel==$1 and "+
+			"name==$2;", channel, name)
+		return err
+	})
+}
 
-	justUpdate := db.commandExists(channel, command)
+func (db dbManager) Triggers(channel string) (res map[string]*Trigger,
+	err error) {
 
-	tx, err := db.Begin()
+	db.log.Println("DB: Loading triggers for", channel)
+	res = make(map[string]*Trigger)
+	err = db.query(func(rows *sql.Rows) error {
+		t := &Trigger{}
+		var name string
+		var cooldown, level int64
+		err := rows.Scan(&name, &t.Pattern, &t.Regex, &t.Command, &cooldown,
+			&level)
+		if err != nil {
+			return err
+		}
+		t.Cooldown = time.Duration(cooldown) * time.Millisecond
+		t.Perm = Permission(level)
+		res[name] = t
+		return nil
+	}, "select name, pattern, regex, command, cooldown, permission "+
+		"from triggers where channel==$1;", channel)
+	return
+}
+
+// SetTrigger creates or updates a trigger.
+func (db dbManager) SetTrigger(channel, name string, t *Trigger) error {
+	db.log.Println("DB: Setting trigger", name, "for", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from triggers where channel==$1 and "+
+			"name==$2;", channel, name)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("insert into triggers(channel, name, pattern, "+
+			"regex, command, cooldown, permission) "+
+			"values($1, $2, $3, $4, $5, $6, $7);", channel, name, t.Pattern,
+			t.Regex, t.Command, int64(t.Cooldown/time.Millisecond),
+			int64(t.Perm))
+		return err
+	})
+}
+
+func (db dbManager) RemoveTrigger(channel, name string) error {
+	db.log.Println("DB: Removing trigger", name, "for", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from triggers where channel==$1 and "+
+			"name==$2;", channel, name)
+		return err
+	})
+}
+
+// scans a row of number, text, author, game, added into a Quote.
+func scanQuote(rows *sql.Rows) (*Quote, error) {
+	q := &Quote{}
+	var number, added int64
+	err := rows.Scan(&number, &q.Text, &q.Author, &q.Game, &added)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	defer tx.Commit()
+	q.Number = int(number)
+	q.Added = time.Unix(added, 0)
+	return q, nil
+}
 
-	if justUpdate {
-		fmt.Println("DB: Updating command", command, "for", channel)
-		sqlStmt, err := tx.Prepare("update commands set reply=$1, mod_only=$2" +
-			" where channel==$3 and name==$4;")
+// Quotes returns every quote in channel sorted by number.
+func (db dbManager) Quotes(channel string) (res []*Quote, err error) {
+	db.log.Println("DB: Loading quotes for", channel)
+	err = db.query(func(rows *sql.Rows) error {
+		q, err := scanQuote(rows)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		defer sqlStmt.Close()
+		res = append(res, q)
+		return nil
+	}, "select number, text, author, game, added from quotes "+
+		"where channel==$1 order by number;", channel)
+	return
+}
 
-		_, err = sqlStmt.Exec(text, modOnly, channel, command)
+// Quote returns the quote numbered n, or nil if it doesn't exist.
+func (db dbManager) Quote(channel string, n int) (q *Quote, err error) {
+	db.log.Println("DB: Getting quote", n, "in", channel)
+	err = db.query(func(rows *sql.Rows) (err error) {
+		q, err = scanQuote(rows)
+		return
+	}, "select number, text, author, game, added from quotes "+
+		"where channel==$1 and number==$2;", channel, int64(n))
+	return
+}
+
+// AddQuote saves q, numbering it after the highest existing quote.
+func (db dbManager) AddQuote(channel string, q *Quote) error {
+	return db.inTx(func(tx *sql.Tx) error {
+		// quotes are numbered from a counter rather than the highest quote
+		// so deleting the last one doesn't free its number
+		var last sql.NullInt64
+		err := tx.QueryRow("select max(last_number) from quote_counters "+
+			"where channel==$1;", channel).Scan(&last)
+		if err != nil {
+			return err
+		}
+
+		q.Number = int(last.Int64) + 1
+
+		_, err = tx.Exec("delete from quote_counters where channel==$1;",
+			channel)
 		if err != nil {
 			return err
 		}
+
+		_, err = tx.Exec("insert into quote_counters(channel, last_number) "+
+			"values($1, $2);", channel, int64(q.Number))
+		if err != nil {
+			return err
+		}
+
+		db.log.Println("DB: Adding quote", q.Number, "for", channel)
+		_, err = tx.Exec("insert into quotes(channel, number, text, author, "+
+			"game, added) values($1, $2, $3, $4, $5, $6);", channel,
+			int64(q.Number), q.Text, q.Author, q.Game, q.Added.Unix())
+		return err
+	})
+}
+
+func (db dbManager) RemoveQuote(channel string, n int) error {
+	db.log.Println("DB: Removing quote", n, "for", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from quotes where channel==$1 and "+
+			"number==$2;", channel, int64(n))
+		return err
+	})
+}
+
+// Filters loads the spam filter rules for channel.
+func (db dbManager) Filters(channel string) (res map[string]*FilterRule,
+	err error) {
+
+	db.log.Println("DB: Loading filters for", channel)
+	res = make(map[string]*FilterRule)
+	err = db.query(func(rows *sql.Rows) error {
+		r := &FilterRule{}
+		var name string
+		var threshold, exempt int64
+		err := rows.Scan(&name, &r.Enabled, &threshold, &exempt)
+		if err != nil {
+			return err
+		}
+		r.Limit = int(threshold)
+		r.Exempt = Permission(exempt)
+		res[name] = r
 		return nil
-	}
+	}, "select name, enabled, threshold, exempt from filters "+
+		"where channel==$1;", channel)
+	return
+}
 
-	fmt.Println("DB: Adding command", command, "for", channel)
-	sqlStmt, err := tx.Prepare(
-		"insert into commands(channel, name, reply, mod_only) " +
-			"values($1, $2, $3, $4);")
-	if err != nil {
-		panic(err)
-	}
-	defer sqlStmt.Close()
+// SetFilter creates or updates a spam filter rule.
+func (db dbManager) SetFilter(channel, name string, r *FilterRule) error {
+	db.log.Println("DB: Setting filter", name, "for", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from filters where channel==$1 and "+
+			"name==$2;", channel, name)
+		if err != nil {
+			return err
+		}
 
-	_, err = sqlStmt.Exec(channel, command, text, modOnly)
-	if err != nil {
+		_, err = tx.Exec("insert into filters(channel, name, enabled, "+
+			"threshold, exempt) values($1, $2, $3, $4, $5);", channel, name,
+			r.Enabled, int64(r.Limit), int64(r.Exempt))
 		return err
-	}
+	})
+}
+
+// Whitelist returns the domains the link filter allows in channel.
+func (db dbManager) Whitelist(channel string) (res []string, err error) {
+	db.log.Println("DB: Loading link whitelist for", channel)
+	err = db.query(func(rows *sql.Rows) error {
+		var domain string
+		err := rows.Scan(&domain)
+		res = append(res, domain)
+		return err
+	}, "select domain from link_whitelist where channel==$1;", channel)
+	return
+}
+
+func (db dbManager) AddWhitelist(channel, domain string) error {
+	db.log.Println("DB: Whitelisting", domain, "for", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from link_whitelist where channel==$1 and "+
+			"domain==$2;", channel, domain)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("insert into link_whitelist(channel, domain) "+
+			"values($1, $2);", channel, domain)
+		return err
+	})
+}
+
+func (db dbManager) RemoveWhitelist(channel, domain string) error {
+	db.log.Println("DB: Removing", domain, "from the whitelist for", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from link_whitelist where channel==$1 and "+
+			"domain==$2;", channel, domain)
+		return err
+	})
+}
+
+func (db dbManager) Strikes(channel, nick string) (count int, last time.Time,
+	err error) {
+
+	err = db.query(func(rows *sql.Rows) error {
+		var n, unix int64
+		err := rows.Scan(&n, &unix)
+		count = int(n)
+		last = time.Unix(unix, 0)
+		return err
+	}, "select strikes, last_strike from strikes where channel==$1 and "+
+		"nick==$2;", channel, nick)
+	return
+}
+
+// SetStrikes saves the strikes of nick, removing them if count is zero.
+func (db dbManager) SetStrikes(channel, nick string, count int,
+	last time.Time) error {
+
+	db.log.Println("DB: Setting strikes for", nick, "in", channel, "to", count)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from strikes where channel==$1 and "+
+			"nick==$2;", channel, nick)
+		if err != nil || count == 0 {
+			return err
+		}
+
+		_, err = tx.Exec("insert into strikes(channel, nick, strikes, "+
+			"last_strike) values($1, $2, $3, $4);", channel, nick,
+			int64(count), last.Unix())
+		return err
+	})
+}
+
+func (db dbManager) StrikeDecay(channel string) (decay time.Duration,
+	err error) {
+
+	db.log.Println("DB: Getting strike decay for", channel)
+	err = db.query(func(rows *sql.Rows) error {
+		var seconds int64
+		err := rows.Scan(&seconds)
+		decay = time.Duration(seconds) * time.Second
+		return err
+	}, "select decay from strike_decay where channel==$1;", channel)
+	return
+}
+
+func (db dbManager) SetStrikeDecay(channel string, decay time.Duration) error {
+	db.log.Println("DB: Setting strike decay for", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from strike_decay where channel==$1;",
+			channel)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		_, err = tx.Exec("insert into strike_decay(channel, decay) "+
+			"values($1, $2);", channel, int64(decay/time.Second))
+		return err
+	})
 }
 
-func (db dbManager) removeCommand(channel, command string) error {
-	fmt.Println("DB: Removing command", command, "for", channel)
-	if !db.commandExists(channel, command) {
-		fmt.Println("DB:", command, "doesn't exist, so no need to remove it")
+func (db dbManager) Blacklist(channel string) (res []*BlacklistEntry,
+	err error) {
+
+	db.log.Println("DB: Loading blacklist for", channel)
+	err = db.query(func(rows *sql.Rows) error {
+		e := &BlacklistEntry{}
+		var kind, action string
+		var timeout int64
+		err := rows.Scan(&e.Pattern, &kind, &action, &timeout)
+		if err != nil {
+			return err
+		}
+		e.Kind = BlacklistKind(kind)
+		e.Action = BlacklistAction(action)
+		e.Timeout = time.Duration(timeout) * time.Second
+		res = append(res, e)
 		return nil
-	}
+	}, "select pattern, kind, action, timeout from blacklist "+
+		"where channel==$1;", channel)
+	return
+}
 
-	tx, err := db.Begin()
-	if err != nil {
-		panic(err)
-	}
-	defer tx.Commit()
+func (db dbManager) AddBlacklist(channel string, e *BlacklistEntry) error {
+	db.log.Println("DB: Blacklisting", e.Pattern, "in", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from blacklist where channel==$1 and "+
+			"pattern==$2;", channel, e.Pattern)
+		if err != nil {
+			return err
+		}
 
-	sqlStmt, err := tx.Prepare(
-		"delete from commands where channel==$1 and name==$2;")
-	if err != nil {
-		panic(err)
-	}
-	defer sqlStmt.Close()
+		_, err = tx.Exec("insert into blacklist(channel, pattern, kind, "+
+			"action, timeout) values($1, $2, $3, $4, $5);", channel,
+			e.Pattern, string(e.Kind), string(e.Action),
+			int64(e.Timeout/time.Second))
+		return err
+	})
+}
 
-	_, err = sqlStmt.Exec(channel, command)
-	if err != nil {
+func (db dbManager) RemoveBlacklist(channel, pattern string) error {
+	db.log.Println("DB: Removing", pattern, "from the blacklist in", channel)
+	return db.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from blacklist where channel==$1 and "+
+			"pattern==$2;", channel, pattern)
 		return err
+	})
+}
+
+func (db dbManager) AuditLog(channel string, n int) (res []*AuditEntry,
+	err error) {
+
+	query := "select added, who, action, target, old_value, new_value " +
+		"from audit_log where channel==$1 order by added desc, seq desc"
+	args := []interface{}{channel}
+	if n > 0 {
+		query += " limit $2"
+		args = append(args, int64(n))
 	}
 
-	return nil
+	err = db.query(func(rows *sql.Rows) error {
+		e := &AuditEntry{}
+		var added int64
+		err := rows.Scan(&added, &e.Who, &e.Action, &e.Target, &e.Before,
+			&e.After)
+		e.Time = time.Unix(added, 0)
+		res = append(res, e)
+		return err
+	}, query+";", args...)
+	return
+}
+
+func (db dbManager) AddAuditEntry(channel string, e *AuditEntry) error {
+	return db.inTx(func(tx *sql.Tx) error {
+		// added only has a resolution of one second, entries are also
+		// numbered so the ones from the same second keep their order
+		var last sql.NullInt64
+		err := tx.QueryRow("select max(seq) from audit_log "+
+			"where channel==$1;", channel).Scan(&last)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("insert into audit_log(channel, added, who, "+
+			"action, target, old_value, new_value, seq) "+
+			"values($1, $2, $3, $4, $5, $6, $7, $8);", channel,
+			e.Time.Unix(), e.Who, e.Action, e.Target, e.Before, e.After,
+			last.Int64+1)
+		return err
+	})
 }
@@ -0,0 +1,79 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// twitch rejects a message from a non-moderator if it's identical to the
+// previous one they sent within this window
+const duplicateWindow = time.Second * 30
+
+// an invisible character twitch doesn't strip, used to make a message differ
+// from the previous one without changing how it looks
+const invisibleChar = "\U000E0000"
+
+// A DuplicateStrategy alters a message that would be identical to the last
+// one sent to a channel within twitch's 30 second duplicate window, so twitch
+// doesn't reject it. It's only used when the bot isn't a moderator in the
+// channel.
+type DuplicateStrategy interface {
+	// Vary returns a variation of text that must be different from text.
+	Vary(text string) string
+}
+
+// DuplicateFunc adapts a function to the DuplicateStrategy interface.
+type DuplicateFunc func(text string) string
+
+func (f DuplicateFunc) Vary(text string) string { return f(text) }
+
+// InvisibleSuffix appends an invisible character to duplicate messages. Since
+// only identical messages are altered, repeating a message alternates between
+// the plain and the altered text. This is the default strategy.
+var InvisibleSuffix DuplicateStrategy = DuplicateFunc(func(text string) string {
+	return text + " " + invisibleChar
+})
+
+// ExtraSpace doubles the first space of duplicate messages, which chat
+// renders as a single space. Messages without spaces get an invisible
+// character instead.
+var ExtraSpace DuplicateStrategy = DuplicateFunc(func(text string) string {
+	i := strings.Index(text, " ")
+	if i < 0 {
+		return InvisibleSuffix.Vary(text)
+	}
+	return text[:i] + " " + text[i:]
+})
+
+// varyWithin varies text with s, cutting the end of text if the variation
+// would be longer than max characters.
+func varyWithin(s DuplicateStrategy, text string, max int) string {
+	runes := []rune(text)
+	for {
+		varied := s.Vary(string(runes))
+		over := utf8.RuneCountInString(varied) - max
+		if over <= 0 || len(runes) == 0 {
+			return varied
+		}
+		if over > len(runes) {
+			over = len(runes)
+		}
+		runes = runes[:len(runes)-over]
+	}
+}
@@ -0,0 +1,85 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestDuplicateStrategies(t *testing.T) {
+	tests := []struct {
+		strategy DuplicateStrategy
+		text     string
+		want     string
+	}{
+		{InvisibleSuffix, "hello", "hello " + invisibleChar},
+		{InvisibleSuffix, "hello there", "hello there " + invisibleChar},
+		{ExtraSpace, "hi there", "hi  there"},
+		{ExtraSpace, "a b c", "a  b c"},
+		{ExtraSpace, "hello", "hello " + invisibleChar},
+		{DuplicateFunc(func(text string) string { return text + "!" }),
+			"hey", "hey!"},
+	}
+
+	for _, test := range tests {
+		got := test.strategy.Vary(test.text)
+		if got != test.want {
+			t.Errorf("Vary(%q) = %q, want %q", test.text, got, test.want)
+		}
+		if got == test.text {
+			t.Errorf("Vary(%q) didn't change the message", test.text)
+		}
+	}
+}
+
+func TestVaryWithin(t *testing.T) {
+	tests := []struct {
+		strategy DuplicateStrategy
+		text     string
+		max      int
+		want     string
+	}{
+		{InvisibleSuffix, "hello", 10, "hello " + invisibleChar},
+		{InvisibleSuffix, "hello", 6, "hell " + invisibleChar},
+		{InvisibleSuffix, "héllo", 6, "héll " + invisibleChar},
+		{ExtraSpace, "hi there", 10, "hi  there"},
+		{ExtraSpace, "hi there", 8, "hi  ther"},
+		{ExtraSpace, "hello", 6, "hell " + invisibleChar},
+	}
+
+	for _, test := range tests {
+		got := varyWithin(test.strategy, test.text, test.max)
+		if got != test.want {
+			t.Errorf("varyWithin(%q, %d) = %q, want %q", test.text, test.max,
+				got, test.want)
+		}
+	}
+}
+
+func TestVaryWithinMaxLength(t *testing.T) {
+	text := strings.Repeat("a ", MaxMessageLength/2)
+	for _, s := range []DuplicateStrategy{InvisibleSuffix, ExtraSpace} {
+		got := varyWithin(s, text, MaxMessageLength)
+		if n := utf8.RuneCountInString(got); n > MaxMessageLength {
+			t.Errorf("varied message is %d characters long", n)
+		}
+		if got == text {
+			t.Errorf("message wasn't varied")
+		}
+	}
+}
@@ -0,0 +1,399 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// names of the spam filters
+const (
+	FilterLinks   = "links"
+	FilterCaps    = "caps"
+	FilterSymbols = "symbols"
+	FilterRepeat  = "repeat"
+	FilterLength  = "length"
+	FilterEmotes  = "emotes"
+)
+
+// messages shorter than this, not counting emotes, are never caught by the
+// caps and symbols filters, so "OK" or "?!" don't count as spam
+const filterMinLength = 15
+
+// A FilterRule configures one of the spam filters in a channel.
+type FilterRule struct {
+	// Enabled is true when the filter checks messages.
+	Enabled bool
+	// Limit is the threshold above which a message is spam. Its meaning
+	// depends on the filter, see FilterNames.
+	Limit int
+	// Exempt is the minimum role that isn't filtered.
+	Exempt Permission
+}
+
+func (r FilterRule) String() string {
+	state := "off"
+	if r.Enabled {
+		state = "on"
+	}
+	return fmt.Sprintf("%s, limit %d, %s+ exempt", state, r.Limit, r.Exempt)
+}
+
+// a chat message as seen by the spam filters
+type filterMessage struct {
+	nick string
+	text string
+	// the text without emotes, so emote names don't count as caps
+	words  string
+	emotes int
+}
+
+type spamFilter struct {
+	name string
+	// what Limit means, for the filter list
+	unit string
+	// shown to users who are warned
+	reason       string
+	defaultLimit int
+	check        func(c *Channel, m *filterMessage, limit int) bool
+}
+
+// spamFilters lists every filter in the order they run.
+var spamFilters = []spamFilter{
+	{FilterLinks, "", "no links without permission", 0, checkLinks},
+	{FilterCaps, "% caps", "please don't shout", 70, checkCaps},
+	{FilterSymbols, "% symbols", "please don't spam symbols", 50,
+		checkSymbols},
+	{FilterRepeat, " repeated characters", "please don't spam", 10,
+		checkRepeat},
+	{FilterLength, " characters", "please keep your messages short", 350,
+		checkLength},
+	{FilterEmotes, " emotes", "please don't spam emotes", 10, checkEmotes},
+}
+
+// FilterNames returns the names of every spam filter.
+func FilterNames() []string {
+	names := make([]string, len(spamFilters))
+	for i, f := range spamFilters {
+		names[i] = f.name
+	}
+	return names
+}
+
+func findFilter(name string) *spamFilter {
+	for i := range spamFilters {
+		if spamFilters[i].name == name {
+			return &spamFilters[i]
+		}
+	}
+	return nil
+}
+
+// matches anything that looks like a domain, with or without a scheme
+var linkRegex = regexp.MustCompile(
+	`(?i)\b(?:[a-z][a-z0-9+.-]*://)?((?:[a-z0-9-]+\.)+[a-z]{2,})\b`)
+
+// links returns the domains of every link in text, in lower case.
+func links(text string) (domains []string) {
+	for _, m := range linkRegex.FindAllStringSubmatch(text, -1) {
+		domains = append(domains, strings.ToLower(m[1]))
+	}
+	return
+}
+
+func checkLinks(c *Channel, m *filterMessage, limit int) bool {
+	for _, domain := range links(m.text) {
+		if !c.IsWhitelisted(domain) {
+			return !c.usePermit(m.nick)
+		}
+	}
+	return false
+}
+
+// returns how many percent of the runes in text satisfy f, ignoring
+// whitespace, or 0 if text is too short to tell.
+func percentOf(text string, count, f func(r rune) bool) int {
+	total, matched := 0, 0
+	for _, r := range text {
+		if !count(r) {
+			continue
+		}
+		total++
+		if f(r) {
+			matched++
+		}
+	}
+	if total < filterMinLength {
+		return 0
+	}
+	return matched * 100 / total
+}
+
+func checkCaps(c *Channel, m *filterMessage, limit int) bool {
+	return percentOf(m.words, unicode.IsLetter, unicode.IsUpper) > limit
+}
+
+func checkSymbols(c *Channel, m *filterMessage, limit int) bool {
+	notSpace := func(r rune) bool { return !unicode.IsSpace(r) }
+	symbol := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+	return percentOf(m.words, notSpace, symbol) > limit
+}
+
+func checkRepeat(c *Channel, m *filterMessage, limit int) bool {
+	run := 0
+	var last rune
+	for _, r := range m.text {
+		if r == last && !unicode.IsSpace(r) {
+			run++
+		} else {
+			run = 1
+		}
+		if run > limit {
+			return true
+		}
+		last = r
+	}
+	return false
+}
+
+func checkLength(c *Channel, m *filterMessage, limit int) bool {
+	return utf8.RuneCountInString(m.text) > limit
+}
+
+func checkEmotes(c *Channel, m *filterMessage, limit int) bool {
+	return m.emotes > limit
+}
+
+// newFilterMessage parses the emotes tag twitch sends with a message, such as
+// "25:0-4,12-16/1902:6-10", which lists the character ranges of each emote.
+func newFilterMessage(nick, text, emotesTag string) *filterMessage {
+	m := &filterMessage{nick: nick, text: text}
+	runes := []rune(text)
+	isEmote := make([]bool, len(runes))
+
+	for _, emote := range strings.Split(emotesTag, "/") {
+		split := strings.SplitN(emote, ":", 2)
+		if len(split) != 2 {
+			continue
+		}
+		for _, pos := range strings.Split(split[1], ",") {
+			bounds := strings.SplitN(pos, "-", 2)
+			if len(bounds) != 2 {
+				continue
+			}
+			start, err1 := strconv.Atoi(bounds[0])
+			end, err2 := strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil || start < 0 || end < start ||
+				end >= len(runes) {
+				continue
+			}
+			m.emotes++
+			for i := start; i <= end; i++ {
+				isEmote[i] = true
+			}
+		}
+	}
+
+	words := make([]rune, 0, len(runes))
+	for i, r := range runes {
+		if !isEmote[i] {
+			words = append(words, r)
+		}
+	}
+	m.words = string(words)
+
+	return m
+}
+
+// FilterRule returns the rule for a spam filter in this channel. Filters that
+// were never configured are disabled, with the default limit and only
+// moderators exempt.
+func (c *Channel) FilterRule(name string) (r FilterRule, err error) {
+	f := findFilter(name)
+	if f == nil {
+		err = fmt.Errorf("Unknown filter %s. Valid filters are: %s.", name,
+			strings.Join(FilterNames(), ", "))
+		return
+	}
+
+	r = FilterRule{Limit: f.defaultLimit, Exempt: PermModerator}
+	c.parent.w.Await(func() {
+		if live := c.filters[name]; live != nil {
+			r = *live
+		}
+	})
+	return
+}
+
+// SetFilterRule configures a spam filter.
+func (c *Channel) SetFilterRule(name string, r FilterRule) error {
+	if findFilter(name) == nil {
+		return fmt.Errorf("Unknown filter %s.", name)
+	}
+
+	if r.Limit < 0 {
+		return fmt.Errorf("Filter limits can't be negative.")
+	}
+
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.SetFilter(c.name, name, &r)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.parent.w.Await(func() { c.filters[name] = &r })
+	c.Println("Set filter", name, "enabled:", r.Enabled, "limit:", r.Limit,
+		"exempt:", r.Exempt)
+	return nil
+}
+
+// FilterList returns a summary of every spam filter's settings.
+func (c *Channel) FilterList() string {
+	lines := make([]string, 0, len(spamFilters))
+	for _, f := range spamFilters {
+		r, _ := c.FilterRule(f.name)
+		state := "off"
+		if r.Enabled {
+			state = "on"
+		}
+		line := f.name + " " + state
+		if len(f.unit) != 0 {
+			line += fmt.Sprintf(" (%d%s)", r.Limit, f.unit)
+		}
+		lines = append(lines, fmt.Sprintf("%s, %s+ exempt", line, r.Exempt))
+	}
+	return strings.Join(lines, " | ")
+}
+
+// normalizes a domain for the whitelist. Links to subdomains of a
+// whitelisted domain are allowed too.
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(domain)
+	if i := strings.Index(domain, "://"); i >= 0 {
+		domain = domain[i+3:]
+	}
+	if i := strings.IndexAny(domain, "/?#"); i >= 0 {
+		domain = domain[:i]
+	}
+	return strings.TrimPrefix(domain, "www.")
+}
+
+// IsWhitelisted returns whether links to domain are allowed.
+func (c *Channel) IsWhitelisted(domain string) bool {
+	domain = normalizeDomain(domain)
+	resp := make(chan bool, 1)
+	c.parent.w.Do(func() {
+		for d := range c.whitelist {
+			if domain == d || strings.HasSuffix(domain, "."+d) {
+				resp <- true
+				close(resp)
+				return
+			}
+		}
+		resp <- false
+		close(resp)
+	})
+	return <-resp
+}
+
+// Whitelist returns the whitelisted domains, sorted.
+func (c *Channel) Whitelist() (res []string) {
+	c.parent.w.Await(func() {
+		for domain := range c.whitelist {
+			res = append(res, domain)
+		}
+	})
+	sort.Strings(res)
+	return
+}
+
+// AddWhitelist allows links to domain and its subdomains.
+func (c *Channel) AddWhitelist(domain string) error {
+	domain = normalizeDomain(domain)
+	if len(links(domain)) != 1 {
+		return fmt.Errorf("%s is not a valid domain.", domain)
+	}
+
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.AddWhitelist(c.name, domain)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.parent.w.Await(func() { c.whitelist[domain] = true })
+	c.Println("Whitelisted", domain)
+	return nil
+}
+
+// RemoveWhitelist removes domain from the whitelist.
+func (c *Channel) RemoveWhitelist(domain string) error {
+	domain = normalizeDomain(domain)
+	exists := false
+	c.parent.w.Await(func() { exists = c.whitelist[domain] })
+	if !exists {
+		return fmt.Errorf("%s is not whitelisted.", domain)
+	}
+
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.RemoveWhitelist(c.name, domain)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.parent.w.Await(func() { delete(c.whitelist, domain) })
+	c.Println("Removed", domain, "from the whitelist")
+	return nil
+}
+
+// checkFilters runs msg through every enabled spam filter the user isn't
+// exempt from. Returns the name of the first filter that caught it, or an
+// empty string. Nothing is filtered when the bot can't moderate the channel.
+func (c *Channel) checkFilters(msg string, user *UserInfo,
+	emotesTag string) string {
+
+	if !c.parent.isModIn(c.name) {
+		return ""
+	}
+
+	var m *filterMessage
+	for _, f := range spamFilters {
+		r, _ := c.FilterRule(f.name)
+		if !r.Enabled || c.HasPermission(user, r.Exempt) {
+			continue
+		}
+
+		if m == nil {
+			m = newFilterMessage(user.Nick, msg, emotesTag)
+		}
+
+		if f.check(c, m, r.Limit) {
+			return f.name
+		}
+	}
+
+	return ""
+}
@@ -16,8 +16,8 @@ import (
 	"strings"
 )
 
-// Post posts a list of files to gist.
-func Post(baseUrl string, accessToken string, isPublic bool,
+// Post posts a list of files to gist using client.
+func Post(client *http.Client, baseUrl string, accessToken string, isPublic bool,
 	filesPath []string, description string) (url string, err error) {
 
 	files := make(map[string]GistJSON.File)
@@ -47,7 +47,7 @@ func Post(baseUrl string, accessToken string, isPublic bool,
 		postUrl = postUrl + "?access_token=" + accessToken
 	}
 
-	resp, err := http.Post(postUrl, "text/json", jsonBody)
+	resp, err := client.Post(postUrl, "text/json", jsonBody)
 	if err != nil {
 		return
 	}
@@ -70,8 +70,8 @@ func Post(baseUrl string, accessToken string, isPublic bool,
 	return
 }
 
-// Update updates an existing gist.
-func Update(baseUrl string, accessToken string, filesPath []string,
+// Update updates an existing gist using client.
+func Update(client *http.Client, baseUrl string, accessToken string, filesPath []string,
 	gistUrl string, description string) (err error) {
 
 	files := make(map[string]GistJSON.File)
@@ -104,9 +104,11 @@ func Update(baseUrl string, accessToken string, filesPath []string,
 	}
 
 	req, err := http.NewRequest("PATCH", postUrl, jsonBody)
-	// handle err
-	resp, err := http.DefaultClient.Do(req)
-	defer resp.Body.Close()
+	if err != nil {
+		return
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return
 	}
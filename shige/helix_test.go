@@ -0,0 +1,131 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripFunc fakes the twitch api.
+type roundTripFunc func(req *http.Request) *http.Response
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req), nil
+}
+
+// fakeHelix makes every api request of c return status and body, and fails
+// the test if the request isn't for wantURL or lacks the credentials.
+func fakeHelix(t *testing.T, c *Channel, wantURL string, status int,
+	body string) {
+
+	c.parent.http = &http.Client{Transport: roundTripFunc(
+		func(req *http.Request) *http.Response {
+			if got := req.URL.String(); got != wantURL {
+				t.Errorf("requested %s, want %s", got, wantURL)
+			}
+			if req.Header.Get("Client-Id") != c.parent.clientID ||
+				req.Header.Get("Authorization") != "Bearer token" {
+
+				t.Errorf("missing credentials: %v", req.Header)
+			}
+			return &http.Response{
+				StatusCode: status,
+				Status:     http.StatusText(status),
+				Body:       ioutil.NopCloser(strings.NewReader(body)),
+			}
+		})}
+}
+
+func TestGame(t *testing.T) {
+	tests := []struct {
+		clientID, roomID string
+		status           int
+		body             string
+		want             string
+		fail             bool
+	}{
+		{"", "1234", http.StatusOK,
+			`{"data":[{"game_name":"osu!"}]}`, "", false},
+		{"client", "", http.StatusOK,
+			`{"data":[{"game_name":"osu!"}]}`, "", true},
+		{"client", "1234", http.StatusOK,
+			`{"data":[{"game_name":"osu!"}]}`, "osu!", false},
+		{"client", "1234", http.StatusOK, `{"data":[]}`, "", false},
+		{"client", "1234", http.StatusUnauthorized, `{}`, "", true},
+		{"client", "1234", http.StatusOK, `not json`, "", true},
+	}
+
+	for _, test := range tests {
+		c, _ := newTestChannel(t)
+		c.parent.clientID = test.clientID
+		c.parent.apiToken = "token"
+		c.setRoomID(test.roomID)
+		fakeHelix(t, c, helixAPI+"channels?broadcaster_id="+test.roomID,
+			test.status, test.body)
+
+		game, err := c.Game()
+		if test.fail != (err != nil) || game != test.want {
+			t.Errorf("Game() with client id %q and room id %q = %q, %v, "+
+				"want %q", test.clientID, test.roomID, game, err, test.want)
+		}
+	}
+}
+
+func TestUptime(t *testing.T) {
+	// the fake clock of the test channel is at 1970-01-12T13:46:40Z
+	tests := []struct {
+		clientID, roomID string
+		status           int
+		body             string
+		want             time.Duration
+		online, fail     bool
+	}{
+		{"", "1234", http.StatusOK,
+			`{"data":[{"started_at":"1970-01-12T12:00:00Z"}]}`, 0, false,
+			true},
+		{"client", "", http.StatusOK,
+			`{"data":[{"started_at":"1970-01-12T12:00:00Z"}]}`, 0, false,
+			true},
+		{"client", "1234", http.StatusOK,
+			`{"data":[{"started_at":"1970-01-12T12:00:00Z"}]}`,
+			time.Hour + time.Minute*46 + time.Second*40, true, false},
+		{"client", "1234", http.StatusOK, `{"data":[]}`, 0, false, false},
+		{"client", "1234", http.StatusUnauthorized, `{}`, 0, false, true},
+		{"client", "1234", http.StatusOK, `not json`, 0, false, true},
+	}
+
+	for _, test := range tests {
+		c, _ := newTestChannel(t)
+		c.parent.clientID = test.clientID
+		c.parent.apiToken = "token"
+		c.setRoomID(test.roomID)
+		fakeHelix(t, c, helixAPI+"streams?user_id="+test.roomID,
+			test.status, test.body)
+
+		uptime, online, err := c.Uptime()
+		if test.fail != (err != nil) || online != test.online ||
+			uptime != test.want {
+
+			t.Errorf("Uptime() with client id %q and room id %q = %v, %v, "+
+				"%v, want %v, %v", test.clientID, test.roomID, uptime,
+				online, err, test.want, test.online)
+		}
+	}
+}
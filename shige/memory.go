@@ -0,0 +1,424 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryChannel is everything memoryStorage knows about a channel.
+type memoryChannel struct {
+	gist     string
+	modGist  string
+	cooldown int32
+	commands map[string]TextCommand
+	aliases  map[string]string
+	counters map[string]int
+	timers   map[string]Timer
+	triggers map[string]Trigger
+	quotes   map[int]Quote
+	quoteSeq int // number of the last quote ever added
+	filters  map[string]FilterRule
+	domains  map[string]bool
+	strikes  map[string]memoryStrikes
+	decay    time.Duration
+	banned   map[string]BlacklistEntry
+	audit    []AuditEntry
+}
+
+type memoryStrikes struct {
+	count int
+	last  time.Time
+}
+
+// memoryStorage keeps everything in memory and forgets it on exit.
+type memoryStorage struct {
+	mutex    sync.Mutex
+	channels map[string]*memoryChannel
+}
+
+// NewMemoryStorage returns a storage that doesn't save anything to disk,
+// which is useful for tests.
+func NewMemoryStorage() Storage {
+	return &memoryStorage{channels: make(map[string]*memoryChannel)}
+}
+
+// channel returns the data for a channel, creating it if needed. The mutex
+// must be held.
+func (m *memoryStorage) channel(name string) *memoryChannel {
+	c := m.channels[name]
+	if c == nil {
+		c = &memoryChannel{
+			commands: make(map[string]TextCommand),
+			aliases:  make(map[string]string),
+			counters: make(map[string]int),
+			timers:   make(map[string]Timer),
+			triggers: make(map[string]Trigger),
+			quotes:   make(map[int]Quote),
+			filters:  make(map[string]FilterRule),
+			domains:  make(map[string]bool),
+			strikes:  make(map[string]memoryStrikes),
+			banned:   make(map[string]BlacklistEntry),
+		}
+		m.channels[name] = c
+	}
+	return c
+}
+
+// do runs f on a channel with the mutex held.
+func (m *memoryStorage) do(channel string, f func(c *memoryChannel)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	f(m.channel(channel))
+}
+
+func (m *memoryStorage) Gist(channel string) (url string, err error) {
+	m.do(channel, func(c *memoryChannel) { url = c.gist })
+	return
+}
+
+func (m *memoryStorage) SetGist(channel, url string) error {
+	m.do(channel, func(c *memoryChannel) { c.gist = url })
+	return nil
+}
+
+func (m *memoryStorage) ModGist(channel string) (url string, err error) {
+	m.do(channel, func(c *memoryChannel) { url = c.modGist })
+	return
+}
+
+func (m *memoryStorage) SetModGist(channel, url string) error {
+	m.do(channel, func(c *memoryChannel) { c.modGist = url })
+	return nil
+}
+
+func (m *memoryStorage) Commands(channel string) (
+	res map[string]*TextCommand, err error) {
+
+	res = make(map[string]*TextCommand)
+	m.do(channel, func(c *memoryChannel) {
+		for name, command := range c.commands {
+			cp := command
+			res[name] = &cp
+		}
+	})
+	return
+}
+
+func (m *memoryStorage) SetCommand(channel, name string,
+	command *TextCommand) error {
+
+	m.do(channel, func(c *memoryChannel) {
+		cp := *command
+		cp.aliases = nil
+		if old, ok := c.commands[name]; ok {
+			cp.Uses = old.Uses
+		}
+		c.commands[name] = cp
+	})
+	return nil
+}
+
+func (m *memoryStorage) RemoveCommand(channel, name string) error {
+	m.do(channel, func(c *memoryChannel) { delete(c.commands, name) })
+	return nil
+}
+
+func (m *memoryStorage) SetCommandUses(channel, name string, uses int) error {
+	m.do(channel, func(c *memoryChannel) {
+		if command, ok := c.commands[name]; ok {
+			command.Uses = uses
+			c.commands[name] = command
+		}
+	})
+	return nil
+}
+
+func (m *memoryStorage) ChannelCooldown(channel string) (cooldown int32,
+	err error) {
+
+	m.do(channel, func(c *memoryChannel) { cooldown = c.cooldown })
+	return
+}
+
+func (m *memoryStorage) SetChannelCooldown(channel string,
+	cooldown int32) error {
+
+	m.do(channel, func(c *memoryChannel) { c.cooldown = cooldown })
+	return nil
+}
+
+func (m *memoryStorage) Aliases(channel string) (res map[string]string,
+	err error) {
+
+	res = make(map[string]string)
+	m.do(channel, func(c *memoryChannel) {
+		for alias, command := range c.aliases {
+			res[alias] = command
+		}
+	})
+	return
+}
+
+func (m *memoryStorage) AddAlias(channel, alias, command string) error {
+	m.do(channel, func(c *memoryChannel) { c.aliases[alias] = command })
+	return nil
+}
+
+func (m *memoryStorage) RemoveAlias(channel, alias, command string) error {
+	m.do(channel, func(c *memoryChannel) {
+		if len(alias) != 0 {
+			delete(c.aliases, alias)
+			return
+		}
+		for a, cmd := range c.aliases {
+			if cmd == command {
+				delete(c.aliases, a)
+			}
+		}
+	})
+	return nil
+}
+
+func (m *memoryStorage) Counters(channel string) (res map[string]int,
+	err error) {
+
+	res = make(map[string]int)
+	m.do(channel, func(c *memoryChannel) {
+		for name, value := range c.counters {
+			res[name] = value
+		}
+	})
+	return
+}
+
+func (m *memoryStorage) SetCounter(channel, name string, value int) error {
+	m.do(channel, func(c *memoryChannel) { c.counters[name] = value })
+	return nil
+}
+
+func (m *memoryStorage) RemoveCounter(channel, name string) error {
+	m.do(channel, func(c *memoryChannel) { delete(c.counters, name) })
+	return nil
+}
+
+func (m *memoryStorage) Timers(channel string) (res map[string]*Timer,
+	err error) {
+
+	res = make(map[string]*Timer)
+	m.do(channel, func(c *memoryChannel) {
+		for name, t := range c.timers {
+			cp := Timer{Text: t.Text, Interval: t.Interval,
+				MinLines: t.MinLines, Enabled: t.Enabled}
+			res[name] = &cp
+		}
+	})
+	return
+}
+
+func (m *memoryStorage) SetTimer(channel, name string, t *Timer) error {
+	m.do(channel, func(c *memoryChannel) { c.timers[name] = *t })
+	return nil
+}
+
+func (m *memoryStorage) RemoveTimer(channel, name string) error {
+	m.do(channel, func(c *memoryChannel) { delete(c.timers, name) })
+	return nil
+}
+
+func (m *memoryStorage) Triggers(channel string) (res map[string]*Trigger,
+	err error) {
+
+	res = make(map[string]*Trigger)
+	m.do(channel, func(c *memoryChannel) {
+		for name, t := range c.triggers {
+			cp := Trigger{Pattern: t.Pattern, Regex: t.Regex,
+				Command: t.Command, Cooldown: t.Cooldown, Perm: t.Perm}
+			res[name] = &cp
+		}
+	})
+	return
+}
+
+func (m *memoryStorage) SetTrigger(channel, name string, t *Trigger) error {
+	m.do(channel, func(c *memoryChannel) { c.triggers[name] = *t })
+	return nil
+}
+
+func (m *memoryStorage) RemoveTrigger(channel, name string) error {
+	m.do(channel, func(c *memoryChannel) { delete(c.triggers, name) })
+	return nil
+}
+
+func (m *memoryStorage) Quotes(channel string) (res []*Quote, err error) {
+	m.do(channel, func(c *memoryChannel) {
+		for _, q := range c.quotes {
+			cp := q
+			res = append(res, &cp)
+		}
+	})
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Number < res[j].Number
+	})
+	return
+}
+
+func (m *memoryStorage) Quote(channel string, n int) (res *Quote, err error) {
+	m.do(channel, func(c *memoryChannel) {
+		if q, ok := c.quotes[n]; ok {
+			res = &q
+		}
+	})
+	return
+}
+
+func (m *memoryStorage) AddQuote(channel string, q *Quote) error {
+	m.do(channel, func(c *memoryChannel) {
+		c.quoteSeq++
+		q.Number = c.quoteSeq
+		c.quotes[q.Number] = *q
+	})
+	return nil
+}
+
+func (m *memoryStorage) RemoveQuote(channel string, n int) error {
+	m.do(channel, func(c *memoryChannel) { delete(c.quotes, n) })
+	return nil
+}
+
+func (m *memoryStorage) Filters(channel string) (
+	res map[string]*FilterRule, err error) {
+
+	res = make(map[string]*FilterRule)
+	m.do(channel, func(c *memoryChannel) {
+		for name, r := range c.filters {
+			cp := r
+			res[name] = &cp
+		}
+	})
+	return
+}
+
+func (m *memoryStorage) SetFilter(channel, name string, r *FilterRule) error {
+	m.do(channel, func(c *memoryChannel) { c.filters[name] = *r })
+	return nil
+}
+
+func (m *memoryStorage) Whitelist(channel string) (res []string, err error) {
+	m.do(channel, func(c *memoryChannel) {
+		for domain := range c.domains {
+			res = append(res, domain)
+		}
+	})
+	sort.Strings(res)
+	return
+}
+
+func (m *memoryStorage) AddWhitelist(channel, domain string) error {
+	m.do(channel, func(c *memoryChannel) { c.domains[domain] = true })
+	return nil
+}
+
+func (m *memoryStorage) RemoveWhitelist(channel, domain string) error {
+	m.do(channel, func(c *memoryChannel) { delete(c.domains, domain) })
+	return nil
+}
+
+func (m *memoryStorage) Strikes(channel, nick string) (count int,
+	last time.Time, err error) {
+
+	m.do(channel, func(c *memoryChannel) {
+		count, last = c.strikes[nick].count, c.strikes[nick].last
+	})
+	return
+}
+
+func (m *memoryStorage) SetStrikes(channel, nick string, count int,
+	last time.Time) error {
+
+	m.do(channel, func(c *memoryChannel) {
+		if count == 0 {
+			delete(c.strikes, nick)
+			return
+		}
+		c.strikes[nick] = memoryStrikes{count, last}
+	})
+	return nil
+}
+
+func (m *memoryStorage) StrikeDecay(channel string) (decay time.Duration,
+	err error) {
+
+	m.do(channel, func(c *memoryChannel) { decay = c.decay })
+	return
+}
+
+func (m *memoryStorage) SetStrikeDecay(channel string,
+	decay time.Duration) error {
+
+	m.do(channel, func(c *memoryChannel) { c.decay = decay })
+	return nil
+}
+
+func (m *memoryStorage) Blacklist(channel string) (res []*BlacklistEntry,
+	err error) {
+
+	m.do(channel, func(c *memoryChannel) {
+		for _, e := range c.banned {
+			cp := BlacklistEntry{Pattern: e.Pattern, Kind: e.Kind,
+				Action: e.Action, Timeout: e.Timeout}
+			res = append(res, &cp)
+		}
+	})
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Pattern < res[j].Pattern
+	})
+	return
+}
+
+func (m *memoryStorage) AddBlacklist(channel string, e *BlacklistEntry) error {
+	m.do(channel, func(c *memoryChannel) { c.banned[e.Pattern] = *e })
+	return nil
+}
+
+func (m *memoryStorage) RemoveBlacklist(channel, pattern string) error {
+	m.do(channel, func(c *memoryChannel) { delete(c.banned, pattern) })
+	return nil
+}
+
+func (m *memoryStorage) AuditLog(channel string, n int) (res []*AuditEntry,
+	err error) {
+
+	m.do(channel, func(c *memoryChannel) {
+		for i := len(c.audit) - 1; i >= 0; i-- {
+			if n > 0 && len(res) >= n {
+				break
+			}
+			cp := c.audit[i]
+			res = append(res, &cp)
+		}
+	})
+	return
+}
+
+func (m *memoryStorage) AddAuditEntry(channel string, e *AuditEntry) error {
+	m.do(channel, func(c *memoryChannel) { c.audit = append(c.audit, *e) })
+	return nil
+}
+
+func (m *memoryStorage) Close() error { return nil }
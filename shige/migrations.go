@@ -0,0 +1,332 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// A migration upgrades the database schema by one version. Column types are
+// written like in ql and translated for other dialects.
+type migration struct {
+	description string
+	// databases created before the schema was versioned may already have
+	// some of the later columns. If column is set and already exists in
+	// table, the migration is skipped.
+	table, column string
+	up            string
+	args          []interface{}
+}
+
+// migrations lists every schema change in order. The schema version of a
+// database is the number of migrations applied to it. Never edit or reorder
+// a migration that has been released, add a new one instead.
+var migrations = []migration{
+	{description: "create commands and gists", up: `
+	create table if not exists commands (
+		channel string not null,
+		name string not null,
+		reply string not null,
+		mod_only bool not null
+	);
+	create table if not exists gists (
+		channel string not null,
+		url string not null
+	);
+	create unique index if not exists commands_index on commands(channel, name);
+	create unique index if not exists gists_index on gists(channel);`},
+
+	{"add command permissions", "commands", "permission", `
+	alter table commands add permission int;
+	update commands set permission = $1 where mod_only == true;
+	update commands set permission = $2 where mod_only == false;`,
+		[]interface{}{int64(PermModerator), int64(PermEveryone)}},
+
+	{"add command usage counts", "commands", "uses", `
+	alter table commands add uses int;
+	update commands set uses = 0;`, nil},
+
+	{"add command global cooldowns", "commands", "global_cooldown", `
+	alter table commands add global_cooldown int;
+	update commands set global_cooldown = 0;`, nil},
+
+	{"add command per-user cooldowns", "commands", "user_cooldown", `
+	alter table commands add user_cooldown int;
+	update commands set user_cooldown = 0;`, nil},
+
+	{"add mod exemptions to command cooldowns", "commands", "mods_exempt", `
+	alter table commands add mods_exempt bool;
+	update commands set mods_exempt = false;`, nil},
+
+	{description: "create channels", up: `
+	create table if not exists channels (
+		channel string not null,
+		cooldown int not null
+	);
+	create unique index if not exists channels_index on channels(channel);`},
+
+	{description: "create aliases", up: `
+	create table if not exists aliases (
+		channel string not null,
+		alias string not null,
+		command string not null
+	);
+	create unique index if not exists aliases_index on aliases(channel, alias);`},
+
+	{description: "create counters", up: `
+	create table if not exists counters (
+		channel string not null,
+		name string not null,
+		value int not null
+	);
+	create unique index if not exists counters_index on counters(channel, name);`},
+
+	{description: "create timers", up: `
+	create table if not exists timers (
+		channel string not null,
+		name string not null,
+		reply string not null,
+		interval int not null,
+		min_lines int not null,
+		enabled bool not null
+	);
+	create unique index if not exists timers_index on timers(channel, name);`},
+
+	{description: "create triggers", up: `
+	create table if not exists triggers (
+		channel string not null,
+		name string not null,
+		pattern string not null,
+		regex bool not null,
+		command string not null,
+		cooldown int not null,
+		permission int not null
+	);
+	create unique index if not exists triggers_index on triggers(channel, name);`},
+
+	{description: "create quotes", up: `
+	create table if not exists quotes (
+		channel string not null,
+		number int not null,
+		text string not null,
+		author string not null,
+		game string not null,
+		added int not null
+	);
+	create unique index if not exists quotes_index on quotes(channel, number);`},
+
+	{description: "create spam filters", up: `
+	create table if not exists filters (
+		channel string not null,
+		name string not null,
+		enabled bool not null,
+		threshold int not null,
+		exempt int not null
+	);
+	create unique index if not exists filters_index on filters(channel, name);
+	create table if not exists link_whitelist (
+		channel string not null,
+		domain string not null
+	);
+	create unique index if not exists link_whitelist_index
+		on link_whitelist(channel, domain);`},
+
+	{description: "create strikes", up: `
+	create table if not exists strikes (
+		channel string not null,
+		nick string not null,
+		strikes int not null,
+		last_strike int not null
+	);
+	create unique index if not exists strikes_index on strikes(channel, nick);
+	create table if not exists strike_decay (
+		channel string not null,
+		decay int not null
+	);
+	create unique index if not exists strike_decay_index
+		on strike_decay(channel);`},
+
+	{description: "create blacklist", up: `
+	create table if not exists blacklist (
+		channel string not null,
+		pattern string not null,
+		kind string not null,
+		action string not null,
+		timeout int not null
+	);
+	create unique index if not exists blacklist_index
+		on blacklist(channel, pattern);`},
+
+	{description: "create audit log", up: `
+	create table if not exists audit_log (
+		channel string not null,
+		added int not null,
+		who string not null,
+		action string not null,
+		target string not null,
+		old_value string not null,
+		new_value string not null,
+		seq int not null
+	);
+	create index if not exists audit_log_index on audit_log(channel, added);`},
+
+	{description: "create mod gists", up: `
+	create table if not exists mod_gists (
+		channel string not null,
+		url string not null
+	);
+	create unique index if not exists mod_gists_index on mod_gists(channel);`},
+
+	{description: "create quote counters", up: `
+	create table if not exists quote_counters (
+		channel string not null,
+		last_number int not null
+	);
+	create unique index if not exists quote_counters_index
+		on quote_counters(channel);
+	insert into quote_counters(channel, last_number)
+		select channel, max(number) from quotes group by channel;`},
+}
+
+// the database schema version this version of the bot expects
+var latestSchemaVersion = len(migrations)
+
+// schemaVersion returns the schema version of the database. Databases from
+// before the schema was versioned are version 0.
+func (db dbManager) schemaVersion() int {
+	var version int64
+	err := db.QueryRow("select version from schema_version;").Scan(&version)
+	if err != nil {
+		// the table doesn't exist yet
+		return 0
+	}
+	return int(version)
+}
+
+// hasColumn checks whether a column exists within tx.
+func hasColumn(tx *sql.Tx, table, column string) bool {
+	rows, err := tx.Query(
+		fmt.Sprintf("select %s from %s limit 1;", column, table))
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// migrate upgrades the database to the latest schema version in a single
+// transaction, so a failed migration leaves the database untouched. If dryRun
+// is true, the migrations are run and then rolled back. Refuses to touch
+// databases that are newer than the bot.
+func (db dbManager) migrate(dryRun bool) (err error) {
+	version := db.schemaVersion()
+	if version > latestSchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than the "+
+			"latest version this bot supports (%d), please upgrade the bot",
+			version, latestSchemaVersion)
+	}
+
+	if version == latestSchemaVersion {
+		db.log.Println("DB: Schema is up to date at version", version)
+		return
+	}
+
+	db.log.Println("DB: Upgrading schema from version", version, "to",
+		latestSchemaVersion)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil || dryRun {
+			tx.Rollback()
+		}
+	}()
+
+	_, err = tx.Exec(db.dialect.schema(`
+	create table if not exists schema_version (version int not null);`))
+	if err != nil {
+		return
+	}
+
+	for i := version; i < latestSchemaVersion; i++ {
+		m := migrations[i]
+		if len(m.column) != 0 && hasColumn(tx, m.table, m.column) {
+			db.log.Printf("DB: Migration %d (%s) was already applied\n",
+				i+1, m.description)
+			continue
+		}
+
+		db.log.Printf("DB: Migration %d: %s\n", i+1, m.description)
+		_, err = tx.Exec(db.dialect.schema(m.up), m.args...)
+		if err != nil {
+			err = fmt.Errorf("migration %d (%s) failed: %v", i+1,
+				m.description, err)
+			return
+		}
+	}
+
+	_, err = tx.Exec("delete from schema_version;")
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec("insert into schema_version(version) values($1);",
+		int64(latestSchemaVersion))
+	if err != nil {
+		return
+	}
+
+	if dryRun {
+		db.log.Println("DB: Dry run, rolling back the migrations")
+		return
+	}
+
+	return tx.Commit()
+}
+
+// DryRunMigrations opens a database like OpenStorage and runs the migrations
+// it would need without saving them, to check that an upgrade would work.
+// The memory backend has no schema and always succeeds.
+func DryRunMigrations(backend, path string, logger *log.Logger) error {
+	if len(path) == 0 {
+		path = commandsFile
+	}
+
+	var d dialect
+	switch backend {
+	case "", StorageQL:
+		d = qlDialect
+	case StorageSQLite:
+		d = sqliteDialect
+	case StorageMemory:
+		return nil
+	default:
+		return fmt.Errorf("unknown storage backend %s", backend)
+	}
+
+	db, err := openDBManager(d, path, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.migrate(true)
+}
@@ -0,0 +1,212 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// migrationIndex returns the schema version right before the migration
+// described as description.
+func migrationIndex(t *testing.T, description string) int {
+	for i, m := range migrations {
+		if m.description == description {
+			return i
+		}
+	}
+	t.Fatalf("no migration called %q", description)
+	return 0
+}
+
+// migrateTo upgrades db to the given schema version instead of the latest.
+func migrateTo(db dbManager, version int) error {
+	latest := latestSchemaVersion
+	latestSchemaVersion = version
+	defer func() { latestSchemaVersion = latest }()
+	return db.migrate(false)
+}
+
+func TestMigrations(t *testing.T) {
+	type statement struct {
+		query string
+		args  []interface{}
+	}
+
+	tests := []struct {
+		name string
+		// the schema version the database is at before the fixture runs
+		from    int
+		fixture []statement
+		dryRun  bool
+		fail    bool
+		// the schema version the database must be at afterwards
+		version int
+		check   func(t *testing.T, db dbManager)
+	}{
+		{name: "fresh database", version: len(migrations)},
+
+		{name: "dry run", from: 1, dryRun: true, version: 1},
+
+		{name: "schema too new", from: len(migrations), fixture: []statement{
+			{"delete from schema_version;", nil},
+			{"insert into schema_version(version) values($1);",
+				[]interface{}{int64(9999)}},
+		}, fail: true, version: 9999},
+
+		{name: "mod only commands", from: 1, fixture: []statement{
+			{"insert into commands(channel, name, reply, mod_only) " +
+				"values($1, $2, $3, $4);",
+				[]interface{}{"#a", "mods", "hi mods", true}},
+			{"insert into commands(channel, name, reply, mod_only) " +
+				"values($1, $2, $3, $4);",
+				[]interface{}{"#a", "all", "hi all", false}},
+		}, version: len(migrations), check: func(t *testing.T,
+			db dbManager) {
+
+			commands, err := db.Commands("#a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]Permission{
+				"mods": PermModerator,
+				"all":  PermEveryone,
+			}
+			for name, perm := range want {
+				c := commands[name]
+				if c == nil || c.Perm != perm || c.Uses != 0 {
+					t.Errorf("!%s is %+v, want permission %v", name, c, perm)
+				}
+			}
+		}},
+
+		// databases from before the schema was versioned can already have
+		// some of the columns
+		{name: "unversioned database", fixture: []statement{
+			{`create table commands (
+				channel string not null,
+				name string not null,
+				reply string not null,
+				mod_only bool not null,
+				permission int
+			);`, nil},
+			{"insert into commands(channel, name, reply, mod_only, " +
+				"permission) values($1, $2, $3, $4, $5);",
+				[]interface{}{"#a", "subs", "hi subs", false,
+					int64(PermSubscriber)}},
+		}, version: len(migrations), check: func(t *testing.T,
+			db dbManager) {
+
+			commands, err := db.Commands("#a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c := commands["subs"]; c == nil || c.Perm != PermSubscriber {
+				t.Errorf("!subs is %+v, want permission %v", c,
+					PermSubscriber)
+			}
+		}},
+
+		{name: "quote counters", from: migrationIndex(t,
+			"create quote counters"), fixture: []statement{
+			{"insert into quotes(channel, number, text, author, game, " +
+				"added) values($1, $2, $3, $4, $5, $6);",
+				[]interface{}{"#a", int64(1), "one", "bob", "", int64(0)}},
+			{"insert into quotes(channel, number, text, author, game, " +
+				"added) values($1, $2, $3, $4, $5, $6);",
+				[]interface{}{"#a", int64(5), "five", "bob", "", int64(0)}},
+		}, version: len(migrations), check: func(t *testing.T,
+			db dbManager) {
+
+			for _, want := range []int{6, 7} {
+				q := &Quote{Text: "new"}
+				if err := db.AddQuote("#a", q); err != nil {
+					t.Fatal(err)
+				}
+				if q.Number != want {
+					t.Errorf("new quote is #%d, want #%d", q.Number, want)
+				}
+				if err := db.RemoveQuote("#a", q.Number); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			q := &Quote{Text: "other"}
+			if err := db.AddQuote("#b", q); err != nil {
+				t.Fatal(err)
+			}
+			if q.Number != 1 {
+				t.Errorf("first quote of #b is #%d, want #1", q.Number)
+			}
+		}},
+	}
+
+	for _, d := range []dialect{qlDialect, sqliteDialect} {
+		t.Run(d.driver, func(t *testing.T) {
+			if !driverBuiltIn(d.driver) {
+				t.Skipf("the %s driver isn't built in", d.driver)
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					path := filepath.Join(t.TempDir(), "test.db")
+					db, err := openDBManager(d, path, nil)
+					if err == nil {
+						err = db.Ping()
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer db.Close()
+
+					if test.from > 0 {
+						if err = migrateTo(db, test.from); err != nil {
+							t.Fatal(err)
+						}
+					}
+
+					tx, err := db.Begin()
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, s := range test.fixture {
+						_, err = tx.Exec(d.schema(s.query), s.args...)
+						if err != nil {
+							tx.Rollback()
+							t.Fatal(err)
+						}
+					}
+					if err = tx.Commit(); err != nil {
+						t.Fatal(err)
+					}
+
+					err = db.migrate(test.dryRun)
+					if test.fail != (err != nil) {
+						t.Errorf("migrate returned %v, want failure %v", err,
+							test.fail)
+					}
+					if v := db.schemaVersion(); v != test.version {
+						t.Errorf("schema version is %d, want %d", v,
+							test.version)
+					}
+					if test.check != nil {
+						test.check(t, db)
+					}
+				})
+			}
+		})
+	}
+}
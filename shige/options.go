@@ -0,0 +1,201 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// An Option configures a Bot created by New.
+type Option func(*Bot)
+
+// A Clock tells the bot what time it is. Cooldowns, timers, caches and
+// uptimes are all measured with it, so a fake clock can be used to test them
+// without waiting.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// WithCredentials sets the twitch account the bot logs in as. oauth is the
+// account's chat token, including the oauth: prefix.
+func WithCredentials(user, oauth string) Option {
+	return func(b *Bot) {
+		b.twitchUser = user
+		b.twitchOAuth = oauth
+	}
+}
+
+// WithChannels sets the channels that are joined once connected. Channel
+// names must include the # prefix.
+func WithChannels(channels ...string) Option {
+	return func(b *Bot) { b.channelList = append(b.channelList, channels...) }
+}
+
+// WithMod specifies whether the bot's account is a moderator in the channels
+// it will join. Moderators get a higher message rate limit.
+func WithMod(isMod bool) Option {
+	return func(b *Bot) { b.isMod = isMod }
+}
+
+// WithCaseSensitive makes text commands case sensitive.
+func WithCaseSensitive(caseSensitive bool) Option {
+	return func(b *Bot) { b.caseSensitive = caseSensitive }
+}
+
+// WithServer makes the bot connect to the irc server at address (host:port)
+// instead of DefaultServer, using TLS if useTLS is true.
+func WithServer(address string, useTLS bool) Option {
+	return func(b *Bot) {
+		b.address = address
+		b.useTLS = useTLS
+	}
+}
+
+// WithLogger sets where the bot logs chat and what it's doing. Defaults to
+// standard output.
+func WithLogger(logger *log.Logger) Option {
+	return func(b *Bot) { b.log = logger }
+}
+
+// WithDatabaseFile sets the ql database file commands and everything else
+// are saved to. Defaults to shige_ql.db in the working directory.
+func WithDatabaseFile(path string) Option {
+	return func(b *Bot) { b.dbFile = path }
+}
+
+// WithStorage replaces the ql database with another storage backend, such as
+// one returned by OpenStorage or a custom implementation. The bot closes it
+// when it shuts down.
+func WithStorage(s Storage) Option {
+	return func(b *Bot) { b.db = s }
+}
+
+// WithHTTPClient sets the client used for twitch api requests and to upload
+// the command lists. Defaults to http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(b *Bot) { b.http = client }
+}
+
+// WithTwitchAPI sets the client id and oauth token used for twitch helix api
+// requests. Without them, follower lookups are skipped and nobody counts as
+// a follower, quotes aren't tagged with a game and the uptime is unknown.
+// The token needs the moderator:read:followers scope.
+func WithTwitchAPI(clientID, token string) Option {
+	return func(b *Bot) {
+		b.clientID = clientID
+		b.apiToken = token
+	}
+}
+
+// WithGistOAuth sets the github oauth token used to upload the command lists
+// to gist with the default publisher.
+func WithGistOAuth(token string) Option {
+	return func(b *Bot) { b.gistOAuth = token }
+}
+
+// WithPublisher replaces gist as the place command lists are uploaded to.
+func WithPublisher(p Publisher) Option {
+	return func(b *Bot) { b.publisher = p }
+}
+
+// WithClock replaces the system clock.
+func WithClock(clock Clock) Option {
+	return func(b *Bot) { b.clock = clock }
+}
+
+// WithRateLimit overrides how many messages the bot sends every period. By
+// default this is 19 messages every 30 seconds, or 99 when running as a
+// moderator.
+func WithRateLimit(messages int, period time.Duration) Option {
+	return func(b *Bot) {
+		b.messageLimit = messages
+		b.ratePeriod = period
+	}
+}
+
+// WithQueueLimit sets how many messages can wait to be sent to each channel
+// and which message is discarded once the queue is full. Defaults to
+// DefaultQueueLimit and DropOldest.
+func WithQueueLimit(limit int, policy DropPolicy) Option {
+	return func(b *Bot) {
+		b.queueLimit = limit
+		b.dropPolicy = policy
+	}
+}
+
+// WithDuplicateStrategy sets how messages that twitch would reject as
+// duplicates are altered. Defaults to InvisibleSuffix.
+func WithDuplicateStrategy(strategy DuplicateStrategy) Option {
+	return func(b *Bot) { b.duplicates = strategy }
+}
+
+// WithMaxChunks sets how many messages a reply longer than MaxMessageLength
+// can be split into. Anything after that is cut. Defaults to
+// DefaultMaxChunks.
+func WithMaxChunks(n int) Option {
+	return func(b *Bot) { b.maxChunks = n }
+}
+
+// WithShutdownTimeout sets how long Run waits for pending work once it's
+// cancelled. Defaults to DefaultShutdownTimeout.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(b *Bot) { b.shutdownTimeout = timeout }
+}
+
+// fills in the defaults for anything the options didn't set.
+func (b *Bot) applyDefaults() {
+	if b.log == nil {
+		b.log = log.New(os.Stdout, "", 0)
+	}
+	if b.address == "" {
+		b.address = DefaultServer
+		b.useTLS = true
+	}
+	if b.dbFile == "" {
+		b.dbFile = commandsFile
+	}
+	if b.http == nil {
+		b.http = http.DefaultClient
+	}
+	if b.publisher == nil {
+		b.publisher = &gistPublisher{b}
+	}
+	if b.clock == nil {
+		b.clock = systemClock{}
+	}
+	if b.duplicates == nil {
+		b.duplicates = InvisibleSuffix
+	}
+	if b.maxChunks <= 0 {
+		b.maxChunks = DefaultMaxChunks
+	}
+	if b.shutdownTimeout <= 0 {
+		b.shutdownTimeout = DefaultShutdownTimeout
+	}
+}
+
+// now returns the current time according to the bot's clock.
+func (b Bot) now() time.Time { return b.clock.Now() }
+
+// since returns the time elapsed since t according to the bot's clock.
+func (b Bot) since(t time.Time) time.Duration { return b.clock.Now().Sub(t) }
@@ -0,0 +1,215 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A Permission is the minimum role a user needs to use a command. Roles are
+// ordered, so a user with a higher role can use every command that requires
+// a lower one.
+type Permission int
+
+const (
+	PermEveryone Permission = iota
+	PermFollower
+	PermSubscriber
+	PermVIP
+	PermModerator
+	PermBroadcaster
+	PermOwner
+)
+
+const (
+	// how long the result of a follower lookup is trusted. Users that
+	// aren't following are checked more often so a new follow counts soon.
+	followerCacheTime    = time.Minute * 10
+	notFollowerCacheTime = time.Minute
+)
+
+// a cached follower lookup
+type followerStatus struct {
+	following bool
+	checked   time.Time
+	// a lookup is running in the background
+	pending bool
+}
+
+var permissionNames = []string{
+	"everyone",
+	"follower",
+	"subscriber",
+	"vip",
+	"moderator",
+	"broadcaster",
+	"owner",
+}
+
+func (p Permission) String() string {
+	if p < 0 || int(p) >= len(permissionNames) {
+		return fmt.Sprintf("Permission(%d)", int(p))
+	}
+	return permissionNames[p]
+}
+
+// ParsePermission returns the permission called name. "mod" and "all" are
+// accepted as short forms of moderator and everyone.
+func ParsePermission(name string) (Permission, error) {
+	name = strings.ToLower(name)
+	switch name {
+	case "mod":
+		return PermModerator, nil
+	case "all":
+		return PermEveryone, nil
+	}
+
+	for i, permName := range permissionNames {
+		if permName == name {
+			return Permission(i), nil
+		}
+	}
+
+	return PermEveryone, fmt.Errorf("Unknown permission level %s. "+
+		"Valid levels are: %s.", name, strings.Join(permissionNames, ", "))
+}
+
+// SetOwners grants the bot owner permission level to a list of nicks.
+func (b Bot) SetOwners(nicknames ...string) {
+	b.w.Await(func() {
+		for _, nick := range nicknames {
+			b.owners[strings.ToLower(nick)] = true
+		}
+	})
+}
+
+// IsOwner returns whether nick has the bot owner permission level.
+func (b Bot) IsOwner(nick string) bool {
+	resp := make(chan bool, 1)
+	b.w.Do(func() {
+		resp <- b.owners[strings.ToLower(nick)]
+		close(resp)
+	})
+	return <-resp
+}
+
+// HasPermission returns whether user is allowed to use commands that require
+// the perm permission level in this channel.
+func (c *Channel) HasPermission(user *UserInfo, perm Permission) bool {
+	if perm <= PermEveryone {
+		return true
+	}
+
+	level := PermEveryone
+	switch {
+	case c.parent.IsOwner(user.Nick):
+		level = PermOwner
+	case user.Broadcaster:
+		level = PermBroadcaster
+	case user.IsMod() || c.IsMod(user.Nick):
+		level = PermModerator
+	case user.VIP:
+		level = PermVIP
+	case user.Subscriber:
+		level = PermSubscriber
+	}
+
+	if level >= perm {
+		return true
+	}
+
+	// followers aren't part of the message tags so we need to ask the api,
+	// which is only worth it when nothing else granted the permission
+	return perm == PermFollower && c.isFollower(user)
+}
+
+// isFollower returns whether user follows the channel according to the
+// cache. Missing or stale entries are refreshed in the background so
+// messages are never held up by the api, which means users count as not
+// following until their first lookup completes.
+func (c *Channel) isFollower(user *UserInfo) bool {
+	following, refresh := false, false
+	c.parent.w.Await(func() {
+		s := c.followers[user.Nick]
+		following = s.following
+
+		ttl := notFollowerCacheTime
+		if s.following {
+			ttl = followerCacheTime
+		}
+		if !s.pending && c.parent.since(s.checked) >= ttl {
+			s.pending = true
+			refresh = true
+		}
+		c.followers[user.Nick] = s
+	})
+
+	if refresh && c.parent.pending.add() {
+		go func() {
+			defer c.parent.pending.done()
+			c.refreshFollower(user)
+		}()
+	}
+	return following
+}
+
+// refreshFollower looks user up and caches the result. Failed lookups are
+// cached as not following, so a broken api isn't asked on every message.
+func (c *Channel) refreshFollower(user *UserInfo) {
+	following, err := c.lookupFollower(user)
+	if err != nil {
+		c.Println("API error:", err)
+	}
+
+	c.parent.w.Await(func() {
+		c.followers[user.Nick] = followerStatus{
+			following: following,
+			checked:   c.parent.now(),
+		}
+	})
+}
+
+// lookupFollower asks the helix api whether user follows the channel. This
+// needs the client id and token set through WithTwitchAPI.
+func (c *Channel) lookupFollower(user *UserInfo) (bool, error) {
+	b := c.parent
+	if len(b.clientID) == 0 {
+		// nothing to log, follower commands just can't work without it
+		return false, nil
+	}
+
+	if len(user.RoomID) == 0 || len(user.UserID) == 0 {
+		return false, fmt.Errorf("no user or room id for %s", user.Nick)
+	}
+
+	// the user is only listed if they're following
+	var body struct {
+		Data []struct {
+			UserID string `json:"user_id"`
+		} `json:"data"`
+	}
+	err := b.helix("channels/followers", url.Values{
+		"broadcaster_id": {user.RoomID},
+		"user_id":        {user.UserID},
+	}, &body)
+	if err != nil {
+		return false, fmt.Errorf("follower lookup for %s: %v", user.Nick, err)
+	}
+	return len(body.Data) != 0, nil
+}
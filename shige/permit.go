@@ -0,0 +1,79 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultPermitDuration is how long a !permit lasts when no duration is
+// given.
+const DefaultPermitDuration = time.Minute
+
+// Permit lets nick post one link that the link filter would otherwise catch
+// within duration. Permits aren't saved, since they only last a short time.
+func (c *Channel) Permit(nick string, duration time.Duration) error {
+	if duration <= 0 {
+		return fmt.Errorf("Permits must last a positive amount of time.")
+	}
+
+	nick = strings.ToLower(strings.TrimPrefix(nick, "@"))
+	expiry := c.parent.now().Add(duration)
+	c.parent.w.Await(func() {
+		// forget expired permits so the table doesn't grow forever
+		for n, t := range c.permits {
+			if !c.parent.now().Before(t) {
+				delete(c.permits, n)
+			}
+		}
+		c.permits[nick] = expiry
+	})
+
+	c.Println("Permitted", nick, "to post a link for", duration)
+	return nil
+}
+
+// HasPermit returns whether nick has a permit that hasn't expired.
+func (c *Channel) HasPermit(nick string) bool {
+	resp := make(chan bool, 1)
+	c.parent.w.Do(func() {
+		t, ok := c.permits[strings.ToLower(nick)]
+		resp <- ok && c.parent.now().Before(t)
+		close(resp)
+	})
+	return <-resp
+}
+
+// usePermit uses up nick's permit. Returns false if nick had no valid
+// permit.
+func (c *Channel) usePermit(nick string) bool {
+	nick = strings.ToLower(nick)
+	resp := make(chan bool, 1)
+	c.parent.w.Do(func() {
+		t, ok := c.permits[nick]
+		delete(c.permits, nick)
+		resp <- ok && c.parent.now().Before(t)
+		close(resp)
+	})
+
+	used := <-resp
+	if used {
+		c.Println(nick, "used their link permit")
+	}
+	return used
+}
@@ -0,0 +1,191 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultStrikeDecay is how long it takes for a strike to expire unless the
+// channel changes it with !strikes decay.
+const DefaultStrikeDecay = time.Hour
+
+// A Punishment is what happens to a user that gets caught by a filter. Ban
+// takes precedence over Timeout, and a Punishment with neither is a warning.
+type Punishment struct {
+	Timeout time.Duration
+	Ban     bool
+}
+
+func (p Punishment) String() string {
+	switch {
+	case p.Ban:
+		return "ban"
+	case p.Timeout > 0:
+		return fmt.Sprintf("%v timeout", p.Timeout)
+	}
+	return "warning"
+}
+
+// Punishments is the punishment for each strike: the first strike is a
+// warning, then timeouts get longer until the user is banned. Users with
+// more strikes than listed get the last punishment.
+var Punishments = []Punishment{
+	{},
+	{Timeout: time.Minute * 10},
+	{Timeout: time.Hour},
+	{Timeout: time.Hour * 24},
+	{Ban: true},
+}
+
+// punishmentFor returns the punishment for a user's nth strike.
+func punishmentFor(strikes int) Punishment {
+	if strikes > len(Punishments) {
+		strikes = len(Punishments)
+	}
+	if strikes < 1 {
+		strikes = 1
+	}
+	return Punishments[strikes-1]
+}
+
+// decayStrikes removes one strike for every decay period that passed since
+// the last one.
+func decayStrikes(count int, elapsed, decay time.Duration) int {
+	if decay <= 0 {
+		return count
+	}
+	count -= int(elapsed / decay)
+	if count < 0 {
+		count = 0
+	}
+	return count
+}
+
+// StrikeDecay returns how long it takes for a strike to expire.
+func (c *Channel) StrikeDecay() (decay time.Duration, err error) {
+	err = c.parent.attemptQuery(func() (err error) {
+		decay, err = c.parent.db.StrikeDecay(c.name)
+		return
+	})
+	if err == nil && decay == 0 {
+		decay = DefaultStrikeDecay
+	}
+	return
+}
+
+// SetStrikeDecay changes how long it takes for a strike to expire.
+func (c *Channel) SetStrikeDecay(decay time.Duration) error {
+	if decay <= 0 {
+		return fmt.Errorf("Strike decay must be positive.")
+	}
+
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.SetStrikeDecay(c.name, decay)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Println("Set strike decay to", decay)
+	return nil
+}
+
+// Strikes returns how many strikes nick currently has.
+func (c *Channel) Strikes(nick string) (count int, err error) {
+	decay, err := c.StrikeDecay()
+	if err != nil {
+		return
+	}
+
+	var last time.Time
+	err = c.parent.attemptQuery(func() (err error) {
+		count, last, err = c.parent.db.Strikes(c.name, strings.ToLower(nick))
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	return decayStrikes(count, c.parent.since(last), decay), nil
+}
+
+// ResetStrikes forgives every strike nick has.
+func (c *Channel) ResetStrikes(nick string) error {
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.SetStrikes(c.name, strings.ToLower(nick), 0,
+			time.Time{})
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Println("Reset strikes for", nick)
+	return nil
+}
+
+// addStrike gives nick a strike and returns how many it now has.
+func (c *Channel) addStrike(nick string) (int, error) {
+	count, err := c.Strikes(nick)
+	if err != nil {
+		return 0, err
+	}
+
+	count++
+	err = c.parent.attemptQuery(func() error {
+		return c.parent.db.SetStrikes(c.name, strings.ToLower(nick), count,
+			c.parent.now())
+	})
+	return count, err
+}
+
+// punish gives a strike to a user caught by a filter and punishes them
+// accordingly. Moderation commands skip the message queue.
+func (c *Channel) punish(user *UserInfo, filter string) {
+	reason := filter + " filter"
+	if f := findFilter(filter); f != nil {
+		reason = f.reason
+	}
+
+	strikes, err := c.addStrike(user.Nick)
+	if err != nil {
+		// still get rid of the message, but don't escalate on a guess
+		c.Println("Failed to record strike for", user.Nick, err)
+		strikes = 1
+	}
+
+	p := punishmentFor(strikes)
+	c.Printf("Filter %s caught %s, strike %d: %s\n", filter, user.Nick,
+		strikes, p)
+	c.auditBot(p.String(), user.Nick, fmt.Sprintf("%s filter, strike %d",
+		filter, strikes))
+
+	switch {
+	case p.Ban:
+		c.ModPrivmsgf("/ban %s %s (strike %d)", user.Nick, reason, strikes)
+
+	case p.Timeout > 0:
+		c.ModPrivmsgf("/timeout %s %d %s (strike %d)", user.Nick,
+			int(p.Timeout/time.Second), reason, strikes)
+
+	default:
+		// purge the message and let them off with a warning
+		c.ModPrivmsgf("/timeout %s 1 %s (warning)", user.Nick, reason)
+		c.ModPrivmsgf("%s, %s (warning)", user.DisplayName, reason)
+	}
+}
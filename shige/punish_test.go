@@ -0,0 +1,177 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when told to.
+type fakeClock struct {
+	mutex sync.Mutex
+	t     time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mutex.Lock()
+	c.t = c.t.Add(d)
+	c.mutex.Unlock()
+}
+
+// nopPublisher pretends to upload command lists.
+type nopPublisher struct{}
+
+func (nopPublisher) Publish(url, description string, files []string,
+	public bool) (string, error) {
+
+	return url, nil
+}
+
+// newTestChannel returns a channel of a bot that keeps everything in memory
+// and never connects.
+func newTestChannel(t *testing.T) (*Channel, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1000000, 0)}
+	b, err := New(
+		WithStorage(NewMemoryStorage()),
+		WithClock(clock),
+		WithPublisher(nopPublisher{}),
+		WithCredentials("shigebot", "oauth:test"),
+		WithMod(true),
+		WithLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := newChannel(b, "#test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, clock
+}
+
+func TestPunishmentFor(t *testing.T) {
+	tests := []struct {
+		strikes int
+		want    Punishment
+	}{
+		{-1, Punishment{}},
+		{0, Punishment{}},
+		{1, Punishment{}},
+		{2, Punishment{Timeout: time.Minute * 10}},
+		{3, Punishment{Timeout: time.Hour}},
+		{4, Punishment{Timeout: time.Hour * 24}},
+		{5, Punishment{Ban: true}},
+		{100, Punishment{Ban: true}},
+	}
+
+	for _, test := range tests {
+		if got := punishmentFor(test.strikes); got != test.want {
+			t.Errorf("punishmentFor(%d) = %v, want %v", test.strikes, got,
+				test.want)
+		}
+	}
+}
+
+func TestDecayStrikes(t *testing.T) {
+	tests := []struct {
+		count   int
+		elapsed time.Duration
+		decay   time.Duration
+		want    int
+	}{
+		{3, 0, time.Hour, 3},
+		{3, time.Minute * 59, time.Hour, 3},
+		{3, time.Hour, time.Hour, 2},
+		{3, time.Hour*2 + time.Minute, time.Hour, 1},
+		{3, time.Hour * 10, time.Hour, 0},
+		{0, time.Hour, time.Hour, 0},
+		{3, time.Hour * 10, 0, 3},
+		{3, time.Hour * 10, -time.Hour, 3},
+	}
+
+	for _, test := range tests {
+		got := decayStrikes(test.count, test.elapsed, test.decay)
+		if got != test.want {
+			t.Errorf("decayStrikes(%d, %v, %v) = %d, want %d", test.count,
+				test.elapsed, test.decay, got, test.want)
+		}
+	}
+}
+
+func TestChannelStrikes(t *testing.T) {
+	c, clock := newTestChannel(t)
+	if err := c.SetStrikeDecay(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		n, err := c.addStrike("Bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != i {
+			t.Fatalf("strike %d: got count %d", i, n)
+		}
+	}
+
+	tests := []struct {
+		advance time.Duration
+		nick    string
+		want    int
+	}{
+		{0, "bob", 3},
+		{0, "BOB", 3},
+		{0, "alice", 0},
+		{time.Minute * 59, "bob", 3},
+		{time.Minute, "bob", 2},
+		{time.Hour * 5, "bob", 0},
+	}
+
+	for _, test := range tests {
+		clock.advance(test.advance)
+		n, err := c.Strikes(test.nick)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != test.want {
+			t.Errorf("%s has %d strikes after %v more, want %d", test.nick,
+				n, test.advance, test.want)
+		}
+	}
+
+	// decayed strikes are counted from the last one
+	if n, _ := c.addStrike("bob"); n != 1 {
+		t.Errorf("got %d strikes after decaying, want 1", n)
+	}
+
+	if err := c.ResetStrikes("Bob"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.Strikes("bob"); n != 0 {
+		t.Errorf("got %d strikes after a reset, want 0", n)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// A Quote is a memorable chat message or stream moment saved by a viewer.
+type Quote struct {
+	// Number identifies the quote within its channel.
+	Number int
+	// Text is the quote itself.
+	Text string
+	// Author is the nickname of the user that added the quote.
+	Author string
+	// Game is the game that was being streamed when the quote was added.
+	Game string
+	// Added is when the quote was added.
+	Added time.Time
+}
+
+func (q *Quote) String() string {
+	if len(q.Game) == 0 {
+		return fmt.Sprintf("Quote #%d: %s [%s]", q.Number, q.Text,
+			q.Added.Format("2006-01-02"))
+	}
+	return fmt.Sprintf("Quote #%d: %s [%s, %s]", q.Number, q.Text, q.Game,
+		q.Added.Format("2006-01-02"))
+}
+
+// AddQuote saves a new quote added by author, tagging it with the game that
+// is currently being streamed.
+func (c *Channel) AddQuote(text, author string) (q *Quote, err error) {
+	game, err := c.Game()
+	if err != nil {
+		// not worth losing the quote over
+		c.Println("Failed to get game for quote:", err)
+	}
+
+	q = &Quote{Text: text, Author: author, Game: game, Added: c.parent.now()}
+	err = c.parent.attemptQuery(func() error {
+		return c.parent.db.AddQuote(c.name, q)
+	})
+	if err != nil {
+		return
+	}
+
+	c.Println("Added quote", q.Number, "by", author, "->", text)
+	return
+}
+
+// Quote returns the quote numbered n.
+func (c *Channel) Quote(n int) (q *Quote, err error) {
+	err = c.parent.attemptQuery(func() (err error) {
+		q, err = c.parent.db.Quote(c.name, n)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	if q == nil {
+		return nil, fmt.Errorf("Quote #%d doesn't exist.", n)
+	}
+	return q, nil
+}
+
+// Quotes returns every quote in the channel sorted by number.
+func (c *Channel) Quotes() (quotes []*Quote, err error) {
+	err = c.parent.attemptQuery(func() (err error) {
+		quotes, err = c.parent.db.Quotes(c.name)
+		return
+	})
+	return
+}
+
+// RandomQuote returns a random quote.
+func (c *Channel) RandomQuote() (*Quote, error) {
+	quotes, err := c.Quotes()
+	if err != nil {
+		return nil, err
+	}
+	if len(quotes) == 0 {
+		return nil, fmt.Errorf("There are no quotes yet.")
+	}
+	return quotes[rand.Intn(len(quotes))], nil
+}
+
+// SearchQuotes returns every quote that contains text, ignoring case.
+func (c *Channel) SearchQuotes(text string) (res []*Quote, err error) {
+	quotes, err := c.Quotes()
+	if err != nil {
+		return
+	}
+
+	text = strings.ToLower(text)
+	for _, q := range quotes {
+		if strings.Contains(strings.ToLower(q.Text), text) {
+			res = append(res, q)
+		}
+	}
+	return
+}
+
+// RemoveQuote deletes the quote numbered n. The numbers of the other quotes
+// don't change.
+func (c *Channel) RemoveQuote(n int) error {
+	if _, err := c.Quote(n); err != nil {
+		return err
+	}
+
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.RemoveQuote(c.name, n)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Println("Removed quote", n)
+	return nil
+}
+
+// QuoteList returns every quote formatted as a markdown list.
+func (c *Channel) QuoteList() (res string, err error) {
+	quotes, err := c.Quotes()
+	if err != nil {
+		return
+	}
+
+	for _, q := range quotes {
+		res += fmt.Sprintf("* %s (added by %s)\n", q, q.Author)
+	}
+	return
+}
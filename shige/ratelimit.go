@@ -17,65 +17,428 @@ package shige
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	period           = 30
-	userMessageLimit = 19
-	modMessageLimit  = 99
+	defaultRatePeriod = time.Second * 30
+	userMessageLimit  = 19
+	modMessageLimit   = 99
+
+	// twitch only lets non-moderators send one message per second to each
+	// channel
+	channelMessageInterval = time.Second
+
+	// DefaultQueueLimit is how many messages can wait to be sent to each
+	// channel before the drop policy kicks in.
+	DefaultQueueLimit = 50
+
+	// how often the queue checks whether the bot is back online
+	disconnectedRetry = time.Second
+	// how often shutdown checks whether the queue has been flushed
+	flushPollInterval = time.Millisecond * 100
+)
+
+// A DropPolicy decides which message is discarded when a channel's outgoing
+// queue is full.
+type DropPolicy int
+
+const (
+	// DropOldest discards the message that has been waiting the longest.
+	DropOldest DropPolicy = iota
+	// DropNewest discards the message that is being queued.
+	DropNewest
 )
 
-type rateLimiter struct {
-	messageCounter        int
-	lastMessageCountReset int64
-	messageLimit          int
+// QueueStats is a snapshot of the outgoing message queue.
+type QueueStats struct {
+	// Channels is the number of messages waiting to be sent to each channel.
+	Channels map[string]int
+	// Priority is the number of moderation actions waiting to be sent.
+	Priority int
+	// Sent is the number of messages sent since the bot was created.
+	Sent int
+	// Dropped is the number of messages discarded because a queue was full.
+	Dropped int
+}
+
+// tokenBucket allows bursts of up to capacity messages and refills one token
+// every interval.
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	interval time.Duration
+	last     time.Time
+}
+
+// newTokenBucket returns a bucket that never lets more than limit messages
+// through in any period, no matter when the window starts. Half the limit is
+// available as a burst and the other half is refilled over the period.
+func newTokenBucket(limit int, period time.Duration) *tokenBucket {
+	capacity := limit / 2
+	if capacity < 1 {
+		capacity = 1
+	}
+	refill := limit - capacity
+	if refill < 1 {
+		refill = 1
+	}
+	return &tokenBucket{
+		capacity: float64(capacity),
+		tokens:   float64(capacity),
+		interval: period / time.Duration(refill),
+	}
+}
+
+func (t *tokenBucket) refill(now time.Time) {
+	if !t.last.IsZero() && now.After(t.last) {
+		t.tokens += float64(now.Sub(t.last)) / float64(t.interval)
+		if t.tokens > t.capacity {
+			t.tokens = t.capacity
+		}
+	}
+	t.last = now
+}
+
+// wait returns how long until a token is available, or 0 if one is available
+// right now.
+func (t *tokenBucket) wait(now time.Time) time.Duration {
+	t.refill(now)
+	if t.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - t.tokens) * float64(t.interval))
+}
+
+func (t *tokenBucket) take() { t.tokens-- }
+
+type outgoingMessage struct {
+	channel, text string
+}
+
+type channelQueue struct {
+	messages []string
+	bucket   *tokenBucket
+	// moderators are exempt from the per-channel limit
+	mod bool
+	// the last message sent to the channel, to avoid duplicates
+	lastText string
+	lastSent time.Time
+}
+
+// messageQueue schedules outgoing messages. Moderation actions are sent
+// first, then every channel's queue is served in turn so a busy channel can't
+// starve the others.
+type messageQueue struct {
+	mutex    sync.Mutex
+	global   *tokenBucket
+	priority []outgoingMessage
+	channels map[string]*channelQueue
+	order    []string
+	next     int
+	limit    int
+	policy   DropPolicy
+	variant  DuplicateStrategy
+	sent     int
+	dropped  int
+	inFlight int
+	wake     chan bool
+	stop     chan bool
+	// closed once runQueue returns
+	exited chan bool
 }
 
-func (b *Bot) initRateLimiter() {
-	rl := &rateLimiter{0, 0, userMessageLimit}
+func (b *Bot) initQueue() {
+	limit := userMessageLimit
 	if b.isMod {
-		rl.messageLimit = modMessageLimit
+		limit = modMessageLimit
 	}
-	b.rateLimiter = rl
-	fmt.Printf("> Initialized rate limiter with msglimit=%d\n", rl.messageLimit)
+	if b.messageLimit > 0 {
+		limit = b.messageLimit
+	}
+
+	period := defaultRatePeriod
+	if b.ratePeriod > 0 {
+		period = b.ratePeriod
+	}
+
+	queueLimit := DefaultQueueLimit
+	if b.queueLimit > 0 {
+		queueLimit = b.queueLimit
+	}
+
+	b.queue = &messageQueue{
+		global:   newTokenBucket(limit, period),
+		channels: make(map[string]*channelQueue),
+		limit:    queueLimit,
+		policy:   b.dropPolicy,
+		variant:  b.duplicates,
+		wake:     make(chan bool, 1),
+		stop:     make(chan bool),
+		exited:   make(chan bool),
+	}
+	b.log.Printf("> Initialized message queue with msglimit=%d period=%v "+
+		"queue=%d\n", limit, period, queueLimit)
 }
 
-// Privmsgf formats and sends a rate-limited message to channel.
-func (b *Bot) Privmsgf(channel, format string, args ...interface{}) {
-	now := time.Now().Unix()
-	b.w.Await(func() {
-		// TODO: modify this to block for the least time possible
-		rl := b.rateLimiter
-		if rl.lastMessageCountReset == 0 ||
-			now-rl.lastMessageCountReset > period {
-
-			fmt.Printf("> Rate limiter: %d messages sent in %d seconds "+
-				"(limit is %d msgs / %d seconds).\n",
-				rl.messageCounter, now-rl.lastMessageCountReset,
-				rl.messageLimit, period)
-
-			rl.lastMessageCountReset = now
-			rl.messageCounter = 0
-		}
-
-		if rl.messageCounter >= rl.messageLimit {
-			go func() {
-				difference := now - rl.lastMessageCountReset
-				amount := time.Second*period -
-					time.Duration(int64(time.Second)*difference) +
-					time.Millisecond*500
-
-				fmt.Println("!! Rate limit reached, postponing this message by",
-					amount)
-				<-time.After(amount)
-				fmt.Println("Sending delayed message")
-				b.Privmsgf(channel, format, args...)
-			}()
+// push queues the messages in texts one after the other, without other
+// messages in between. Returns the messages that were discarded because the
+// channel's queue was full.
+func (q *messageQueue) push(channel string, texts []string, mod,
+	priority bool) (dropped []string) {
+
+	q.mutex.Lock()
+	defer func() {
+		q.mutex.Unlock()
+		select {
+		case q.wake <- true:
+		default:
+		}
+	}()
+
+	if priority {
+		// moderation actions are never dropped in favour of chat messages
+		for _, text := range texts {
+			q.priority = append(q.priority, outgoingMessage{channel, text})
+		}
+		return
+	}
+
+	cq := q.channels[channel]
+	if cq == nil {
+		cq = &channelQueue{
+			bucket: &tokenBucket{
+				capacity: 1,
+				tokens:   1,
+				interval: channelMessageInterval,
+			},
+		}
+		q.channels[channel] = cq
+		q.order = append(q.order, channel)
+	}
+	cq.mod = mod
+
+	for _, text := range texts {
+		if len(cq.messages) >= q.limit {
+			q.dropped++
+			if q.policy == DropNewest {
+				dropped = append(dropped, text)
+				continue
+			}
+			dropped = append(dropped, cq.messages[0])
+			cq.messages = cq.messages[1:]
+		}
+		cq.messages = append(cq.messages, text)
+	}
+	return
+}
+
+// pop takes the next message that the rate limits allow to be sent. If there
+// is none, it returns how long to wait before trying again, or 0 if every
+// queue is empty.
+func (q *messageQueue) pop(now time.Time) (*outgoingMessage, time.Duration) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	globalWait := q.global.wait(now)
+
+	if len(q.priority) > 0 {
+		if globalWait > 0 {
+			return nil, globalWait
+		}
+		msg := q.priority[0]
+		q.priority = q.priority[1:]
+		q.global.take()
+		q.sent++
+		q.inFlight++
+		return &msg, 0
+	}
+
+	var wait time.Duration
+	for i := range q.order {
+		idx := (q.next + i) % len(q.order)
+		name := q.order[idx]
+		cq := q.channels[name]
+		if len(cq.messages) == 0 {
+			continue
+		}
+
+		w := globalWait
+		if !cq.mod {
+			if cw := cq.bucket.wait(now); cw > w {
+				w = cw
+			}
+		}
+
+		if w == 0 {
+			text := cq.messages[0]
+			cq.messages = cq.messages[1:]
+			q.global.take()
+			if !cq.mod {
+				cq.bucket.take()
+				if text == cq.lastText &&
+					now.Sub(cq.lastSent) < duplicateWindow {
+
+					text = varyWithin(q.variant, text, MaxMessageLength)
+				}
+			}
+			cq.lastText = text
+			cq.lastSent = now
+			q.next = idx + 1
+			q.sent++
+			q.inFlight++
+			return &outgoingMessage{name, text}, 0
+		}
+
+		if wait == 0 || w < wait {
+			wait = w
+		}
+	}
+
+	return nil, wait
+}
+
+// done marks a message returned by pop as written to the connection.
+func (q *messageQueue) done() {
+	q.mutex.Lock()
+	q.inFlight--
+	q.mutex.Unlock()
+}
+
+func (q *messageQueue) stats() QueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	s := QueueStats{
+		Channels: make(map[string]int),
+		Priority: len(q.priority),
+		Sent:     q.sent,
+		Dropped:  q.dropped,
+	}
+	for name, cq := range q.channels {
+		s.Channels[name] = len(cq.messages)
+	}
+	return s
+}
+
+func (q *messageQueue) empty() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.priority) > 0 || q.inFlight > 0 {
+		return false
+	}
+	for _, cq := range q.channels {
+		if len(cq.messages) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// flush waits until every queued message has been sent or deadline fires.
+// Returns false if it timed out.
+func (q *messageQueue) flush(deadline <-chan time.Time) bool {
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+
+	for !q.empty() {
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
+
+// runQueue sends queued messages as the rate limits allow until the queue is
+// stopped. Messages are held while the bot is disconnected.
+func (b *Bot) runQueue() {
+	q := b.queue
+	defer close(q.exited)
+
+	for {
+		var wait time.Duration
+		if b.State() != StateConnected {
+			wait = disconnectedRetry
+		} else {
+			var msg *outgoingMessage
+			msg, wait = q.pop(b.now())
+			if msg != nil {
+				b.irc.Privmsg(msg.channel, msg.text)
+				q.done()
+				continue
+			}
+		}
+
+		// with nothing to wait for, sleep until a message is queued
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		stopped := false
+		select {
+		case <-q.wake:
+		case <-timeout:
+		case <-q.stop:
+			stopped = true
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if stopped {
 			return
 		}
+	}
+}
+
+// QueueStats returns how many messages are waiting to be sent and how many
+// were sent or dropped so far.
+func (b *Bot) QueueStats() QueueStats {
+	return b.queue.stats()
+}
+
+// whether the bot is exempt from the per-channel message limit in channel.
+func (b *Bot) isModIn(channel string) bool {
+	if b.isMod {
+		return true
+	}
+
+	nick := strings.ToLower(b.twitchUser)
+	if channel == "#"+nick {
+		return true
+	}
+
+	c := b.Channel(channel)
+	return c != nil && c.IsMod(nick)
+}
+
+func (b *Bot) enqueue(channel string, texts []string, priority bool) {
+	dropped := b.queue.push(channel, texts, b.isModIn(channel), priority)
+	for _, text := range dropped {
+		b.log.Println("!! Message queue for", channel, "is full, dropped:",
+			text)
+	}
+}
+
+// Privmsgf formats a message and queues it to be sent to channel as soon as
+// the rate limits allow. Messages to the same channel are sent in order.
+// Messages longer than twitch's limit are split into several (see
+// MaxMessageLength).
+func (b *Bot) Privmsgf(channel, format string, args ...interface{}) {
+	b.enqueue(channel, splitMessage(fmt.Sprintf(format, args...),
+		MaxMessageLength, b.maxChunks), false)
+}
 
-		b.irc.Privmsgf(channel, fmt.Sprintf("%s %s", format, b.randStr()), args...)
-		rl.messageCounter++
-	})
+// ModPrivmsgf is like Privmsgf but for moderation commands such as /timeout,
+// which are sent before any other queued message and are never dropped.
+func (b *Bot) ModPrivmsgf(channel, format string, args ...interface{}) {
+	b.enqueue(channel, []string{fmt.Sprintf(format, args...)}, true)
 }
@@ -0,0 +1,104 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketBurst(t *testing.T) {
+	tests := []struct {
+		limit    int
+		period   time.Duration
+		burst    int
+		interval time.Duration
+	}{
+		{1, time.Second * 30, 1, time.Second * 30},
+		{3, time.Second * 30, 1, time.Second * 15},
+		{20, time.Second * 30, 10, time.Second * 3},
+		{100, time.Second * 30, 50, time.Millisecond * 600},
+	}
+
+	start := time.Unix(1000000, 0)
+	for _, test := range tests {
+		b := newTokenBucket(test.limit, test.period)
+		for i := 0; i < test.burst; i++ {
+			if wait := b.wait(start); wait != 0 {
+				t.Fatalf("limit %d: message %d waits %v, want 0", test.limit,
+					i+1, wait)
+			}
+			b.take()
+		}
+
+		if wait := b.wait(start); wait != test.interval {
+			t.Errorf("limit %d: after the burst got wait %v, want %v",
+				test.limit, wait, test.interval)
+		}
+		if wait := b.wait(start.Add(test.interval / 2)); wait !=
+			test.interval/2 {
+
+			t.Errorf("limit %d: half way got wait %v, want %v", test.limit,
+				wait, test.interval/2)
+		}
+		if wait := b.wait(start.Add(test.interval)); wait != 0 {
+			t.Errorf("limit %d: after an interval got wait %v, want 0",
+				test.limit, wait)
+		}
+	}
+}
+
+func TestTokenBucketNeverExceedsLimit(t *testing.T) {
+	tests := []struct {
+		limit  int
+		period time.Duration
+	}{
+		{1, time.Second * 30},
+		{3, time.Second * 30},
+		{20, time.Second * 30},
+		{100, time.Second * 30},
+	}
+
+	for _, test := range tests {
+		b := newTokenBucket(test.limit, test.period)
+		now := time.Unix(1000000, 0)
+		var sent []time.Time
+
+		// send as fast as the bucket allows for a few periods
+		for len(sent) < test.limit*5 {
+			if wait := b.wait(now); wait > 0 {
+				now = now.Add(wait)
+				continue
+			}
+			b.take()
+			sent = append(sent, now)
+		}
+
+		for i := range sent {
+			n := 0
+			for _, when := range sent[i:] {
+				if when.Sub(sent[i]) < test.period {
+					n++
+				}
+			}
+			if n > test.limit {
+				t.Errorf("limit %d: sent %d messages in the %v after %v",
+					test.limit, n, test.period, sent[i].Sub(sent[0]))
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultShutdownTimeout is how long Run waits for pending work such as
+// command handlers, delayed messages and gist uploads once it's cancelled.
+const DefaultShutdownTimeout = time.Second * 10
+
+// ErrShutdownTimeout is returned by Run when pending work didn't finish in
+// time and was abandoned.
+var ErrShutdownTimeout = errors.New("shutdown timed out, pending work " +
+	"was abandoned")
+
+// pendingWork keeps track of work running in the background, such as
+// command handlers and delayed messages, so shutdown can wait for it. Once
+// closed, no new work is accepted.
+type pendingWork struct {
+	mutex  sync.Mutex
+	wg     sync.WaitGroup
+	closed bool
+}
+
+// add registers a new piece of work. Returns false if the bot is shutting
+// down, in which case the work should not be started.
+func (p *pendingWork) add() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return false
+	}
+	p.wg.Add(1)
+	return true
+}
+
+// done marks a piece of work registered by add as finished.
+func (p *pendingWork) done() {
+	p.wg.Done()
+}
+
+// closeAndWait stops accepting work and waits for the pending work until
+// deadline fires. Returns false if it timed out.
+func (p *pendingWork) closeAndWait(deadline <-chan time.Time) bool {
+	p.mutex.Lock()
+	p.closed = true
+	p.mutex.Unlock()
+
+	idle := make(chan bool)
+	go func() {
+		p.wg.Wait()
+		close(idle)
+	}()
+
+	select {
+	case <-idle:
+		return true
+	case <-deadline:
+		return false
+	}
+}
+
+// shutdown parts every channel, waits for pending work and outgoing messages,
+// disconnects and closes the database. supervised is closed once the
+// connection supervisor returns.
+func (b *Bot) shutdown(supervised chan bool) (err error) {
+	deadline := time.After(b.shutdownTimeout)
+
+	connected := false
+	channels := make([]string, 0)
+	b.w.Await(func() {
+		b.setQuitting()
+		connected = b.state == StateConnected
+		for name := range b.channels {
+			channels = append(channels, name)
+		}
+	})
+
+	if connected {
+		for _, name := range channels {
+			b.log.Println("> Leaving", name)
+			b.irc.Part(name)
+		}
+	}
+
+	b.log.Println("> Waiting for pending work")
+	clean := b.pending.closeAndWait(deadline)
+
+	if connected {
+		b.log.Println("> Flushing message queue")
+		clean = b.queue.flush(deadline) && clean
+	}
+
+	// the irc library sends messages in order, so the parts and the last
+	// messages go out before the QUIT
+	if connected {
+		b.irc.Quit()
+	}
+
+	select {
+	case <-supervised:
+	case <-deadline:
+		clean = false
+	}
+
+	close(b.stopTimers)
+	close(b.queue.stop)
+
+	// the queue uses the worker to check the connection state
+	select {
+	case <-b.queue.exited:
+	case <-deadline:
+		clean = false
+	}
+
+	if !clean {
+		// abandoned work might still use the worker, so it's left running
+		b.log.Println("> Shutdown timed out")
+		err = ErrShutdownTimeout
+	} else {
+		b.log.Println("Waiting for worker to terminate")
+		b.counterWorker.Terminate()
+		b.counterWorker.Join()
+		b.w.Terminate()
+		b.w.Join()
+	}
+
+	b.log.Println("> Closing database")
+	if dberr := b.db.Close(); dberr != nil && err == nil {
+		err = dberr
+	}
+
+	return
+}
@@ -0,0 +1,96 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxMessageLength is the maximum length of a chat message in
+	// characters. Twitch silently cuts anything after it.
+	MaxMessageLength = 500
+
+	// DefaultMaxChunks is how many messages a long reply can be split into
+	// by default.
+	DefaultMaxChunks = 3
+
+	// appended to the last chunk when a reply is cut short
+	ellipsis = "..."
+)
+
+// splitMessage splits text into chunks of at most max characters, breaking
+// at the last whitespace before the limit whenever possible. Spacing within a
+// chunk is kept as is, only the whitespace a chunk is broken at is dropped.
+// /me messages keep the prefix on every chunk. If more than maxChunks chunks
+// are needed, the last one is cut and ends with an ellipsis. Other commands
+// such as /timeout are never split.
+func splitMessage(text string, max, maxChunks int) []string {
+	if utf8.RuneCountInString(text) <= max {
+		return []string{text}
+	}
+
+	prefix := ""
+	if strings.HasPrefix(text, "/me ") {
+		prefix = "/me "
+		text = text[len(prefix):]
+	} else if strings.HasPrefix(text, "/") || strings.HasPrefix(text, ".") {
+		return []string{text}
+	}
+
+	limit := max - utf8.RuneCountInString(prefix)
+	chunks := make([]string, 0)
+	runes := []rune(strings.TrimSpace(text))
+
+	for len(runes) > 0 {
+		if len(runes) <= limit {
+			chunks = append(chunks, prefix+string(runes))
+			break
+		}
+
+		// the character right after the limit can be the break too. Words
+		// that don't fit in a chunk on their own are cut at the limit,
+		// which never breaks multi-byte characters since these are runes
+		end, next := limit, limit
+		for i := limit; i > 0; i-- {
+			if unicode.IsSpace(runes[i]) {
+				end, next = i, i+1
+				break
+			}
+		}
+
+		chunks = append(chunks, prefix+strings.TrimRightFunc(
+			string(runes[:end]), unicode.IsSpace))
+
+		runes = runes[next:]
+		for len(runes) > 0 && unicode.IsSpace(runes[0]) {
+			runes = runes[1:]
+		}
+	}
+
+	if maxChunks > 0 && len(chunks) > maxChunks {
+		chunks = chunks[:maxChunks]
+		last := []rune(chunks[maxChunks-1])
+		if len(last)+len(ellipsis) > max {
+			last = last[:max-len(ellipsis)]
+		}
+		chunks[maxChunks-1] = string(last) + ellipsis
+	}
+
+	return chunks
+}
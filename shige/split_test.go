@@ -0,0 +1,81 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		text      string
+		max       int
+		maxChunks int
+		want      []string
+	}{
+		{"short", 10, 0, []string{"short"}},
+		{"exactly10!", 10, 0, []string{"exactly10!"}},
+		{"aa bb cc dd", 5, 0, []string{"aa bb", "cc dd"}},
+		{"aa  bb   cc dd", 6, 0, []string{"aa  bb", "cc dd"}},
+		{"aaaaaa bb", 6, 0, []string{"aaaaaa", "bb"}},
+		{"abcdefghij", 4, 0, []string{"abcd", "efgh", "ij"}},
+		{"aa\tbb\ncc", 5, 0, []string{"aa\tbb", "cc"}},
+		{"  padded words  here  ", 8, 0, []string{"padded", "words", "here"}},
+		{"/me aa bb cc", 9, 0, []string{"/me aa bb", "/me cc"}},
+		{"/me aa  bb cc", 9, 0, []string{"/me aa", "/me bb cc"}},
+		{"/timeout someone 600 a long reason", 10, 0,
+			[]string{"/timeout someone 600 a long reason"}},
+		{".ban someone for a long reason", 10, 0,
+			[]string{".ban someone for a long reason"}},
+		{"aa bb cc dd ee ff", 5, 2, []string{"aa bb", "cc..."}},
+		{"aa bb cc dd", 5, 2, []string{"aa bb", "cc dd"}},
+		{"héllo wörld", 5, 0, []string{"héllo", "wörld"}},
+		{"héllo wörld  x", 5, 2, []string{"héllo", "wö..."}},
+		{"日本語のテキスト", 3, 0, []string{"日本語", "のテキ", "スト"}},
+	}
+
+	for _, test := range tests {
+		got := splitMessage(test.text, test.max, test.maxChunks)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitMessage(%q, %d, %d) = %q, want %q", test.text,
+				test.max, test.maxChunks, got, test.want)
+		}
+	}
+}
+
+func TestSplitMessageLimits(t *testing.T) {
+	text := strings.Repeat("lorem ipsum dolor sit amet ", 100)
+	for _, max := range []int{10, 50, MaxMessageLength} {
+		chunks := splitMessage(text, max, DefaultMaxChunks)
+		if len(chunks) != DefaultMaxChunks {
+			t.Errorf("max %d: got %d chunks, want %d", max, len(chunks),
+				DefaultMaxChunks)
+		}
+		for _, chunk := range chunks {
+			if n := utf8.RuneCountInString(chunk); n > max {
+				t.Errorf("max %d: chunk %q is %d characters long", max,
+					chunk, n)
+			}
+		}
+		if !strings.HasSuffix(chunks[len(chunks)-1], ellipsis) {
+			t.Errorf("max %d: last chunk %q has no ellipsis", max,
+				chunks[len(chunks)-1])
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// A Storage saves everything the bot needs to remember across restarts for
+// each channel. Channels are identified by their name, including the #.
+// Getters return fresh values that the bot is free to modify. Every call
+// reports failures through its error instead of panicking, and a write that
+// fails must leave the stored data as it was.
+//
+// Library users can plug their own storage through WithStorage.
+type Storage interface {
+	// Gist returns the url the channel's command list is published at, or
+	// an empty string if it hasn't been published yet.
+	Gist(channel string) (string, error)
+	SetGist(channel, url string) error
+	// ModGist returns the url of the secret gist the channel's moderation
+	// lists are published at, or an empty string.
+	ModGist(channel string) (string, error)
+	SetModGist(channel, url string) error
+
+	// Commands returns the channel's text commands by name.
+	Commands(channel string) (map[string]*TextCommand, error)
+	// SetCommand creates or updates a text command. The usage count is only
+	// saved when the command is created, see SetCommandUses.
+	SetCommand(channel, name string, c *TextCommand) error
+	RemoveCommand(channel, name string) error
+	SetCommandUses(channel, name string, uses int) error
+
+	// ChannelCooldown returns the default command cooldown in milliseconds.
+	ChannelCooldown(channel string) (int32, error)
+	SetChannelCooldown(channel string, cooldown int32) error
+
+	// Aliases returns every alias in the channel mapped to the name of the
+	// command it refers to.
+	Aliases(channel string) (map[string]string, error)
+	AddAlias(channel, alias, command string) error
+	// RemoveAlias removes alias, or every alias of command if alias is
+	// empty.
+	RemoveAlias(channel, alias, command string) error
+
+	Counters(channel string) (map[string]int, error)
+	// SetCounter creates or updates a counter.
+	SetCounter(channel, name string, value int) error
+	RemoveCounter(channel, name string) error
+
+	Timers(channel string) (map[string]*Timer, error)
+	// SetTimer creates or updates a timer.
+	SetTimer(channel, name string, t *Timer) error
+	RemoveTimer(channel, name string) error
+
+	Triggers(channel string) (map[string]*Trigger, error)
+	// SetTrigger creates or updates a trigger.
+	SetTrigger(channel, name string, t *Trigger) error
+	RemoveTrigger(channel, name string) error
+
+	// Quotes returns every quote in the channel sorted by number.
+	Quotes(channel string) ([]*Quote, error)
+	// Quote returns the quote numbered n, or nil if it doesn't exist.
+	Quote(channel string, n int) (*Quote, error)
+	// AddQuote saves q, setting its number to one past the last quote ever
+	// added to the channel. Numbers of deleted quotes are never reused.
+	AddQuote(channel string, q *Quote) error
+	RemoveQuote(channel string, n int) error
+
+	// Filters returns the spam filter rules the channel has configured, by
+	// filter name.
+	Filters(channel string) (map[string]*FilterRule, error)
+	// SetFilter creates or updates the rule for a spam filter.
+	SetFilter(channel, name string, r *FilterRule) error
+
+	// Whitelist returns the domains the link filter allows.
+	Whitelist(channel string) ([]string, error)
+	AddWhitelist(channel, domain string) error
+	RemoveWhitelist(channel, domain string) error
+
+	// Strikes returns how many filter violations nick has in the channel
+	// and when the last one was, or zero values if there are none.
+	Strikes(channel, nick string) (count int, last time.Time, err error)
+	SetStrikes(channel, nick string, count int, last time.Time) error
+	// StrikeDecay returns how long it takes for a strike to expire, or zero
+	// if the channel uses the default.
+	StrikeDecay(channel string) (time.Duration, error)
+	SetStrikeDecay(channel string, decay time.Duration) error
+
+	// Blacklist returns the channel's blacklisted words, phrases and
+	// regular expressions.
+	Blacklist(channel string) ([]*BlacklistEntry, error)
+	// AddBlacklist creates or replaces the entry with the same pattern.
+	AddBlacklist(channel string, e *BlacklistEntry) error
+	RemoveBlacklist(channel, pattern string) error
+
+	// AuditLog returns the last n entries of the channel's moderation log,
+	// newest first, or every entry if n is zero or less.
+	AuditLog(channel string, n int) ([]*AuditEntry, error)
+	AddAuditEntry(channel string, e *AuditEntry) error
+
+	// Close saves anything that's pending and releases the storage.
+	Close() error
+}
+
+// storage backends understood by OpenStorage
+const (
+	StorageQL     = "ql"
+	StorageSQLite = "sqlite"
+	StorageMemory = "memory"
+)
+
+// OpenStorage opens one of the built-in storage backends by name (see the
+// Storage constants). path is the database file, ignored by the memory
+// backend. An empty backend means ql and an empty path means shige_ql.db.
+func OpenStorage(backend, path string, logger *log.Logger) (Storage, error) {
+	if len(path) == 0 {
+		path = commandsFile
+	}
+
+	switch backend {
+	case "", StorageQL:
+		return NewQLStorage(path, logger)
+	case StorageSQLite:
+		return NewSQLiteStorage(path, logger)
+	case StorageMemory:
+		return NewMemoryStorage(), nil
+	}
+
+	return nil, fmt.Errorf("unknown storage backend %s", backend)
+}
@@ -0,0 +1,246 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// driverBuiltIn returns whether the sql driver called name is compiled in,
+// which for sqlite depends on the sqlite build tag.
+func driverBuiltIn(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
+
+// testBackends opens a fresh instance of every storage backend and calls f
+// with it. The sql backends are skipped if their driver isn't built in.
+func testBackends(t *testing.T, f func(t *testing.T, s Storage)) {
+	backends := []struct {
+		name   string
+		driver string
+	}{
+		{StorageMemory, ""},
+		{StorageQL, qlDialect.driver},
+		{StorageSQLite, sqliteDialect.driver},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			if len(backend.driver) != 0 && !driverBuiltIn(backend.driver) {
+				t.Skipf("the %s driver isn't built in", backend.driver)
+			}
+
+			path := filepath.Join(t.TempDir(), "test.db")
+			s, err := OpenStorage(backend.name, path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			f(t, s)
+		})
+	}
+}
+
+func quoteNumbers(t *testing.T, s Storage, channel string) (res []int) {
+	quotes, err := s.Quotes(channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range quotes {
+		res = append(res, q.Number)
+	}
+	return
+}
+
+func TestStorageQuotes(t *testing.T) {
+	testBackends(t, func(t *testing.T, s Storage) {
+		added := time.Unix(1000000, 0)
+		add := func(channel, text string) int {
+			q := &Quote{Text: text, Author: "bob", Added: added}
+			if err := s.AddQuote(channel, q); err != nil {
+				t.Fatal(err)
+			}
+			return q.Number
+		}
+		remove := func(channel string, n int) {
+			if err := s.RemoveQuote(channel, n); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		steps := []struct {
+			do   func() int
+			want int
+			list []int
+		}{
+			{func() int { return add("#a", "one") }, 1, []int{1}},
+			{func() int { return add("#a", "two") }, 2, []int{1, 2}},
+			{func() int { return add("#a", "three") }, 3, []int{1, 2, 3}},
+			{func() int { remove("#a", 2); return 0 }, 0, []int{1, 3}},
+			// numbers of deleted quotes, even the last one, aren't reused
+			{func() int { remove("#a", 3); return 0 }, 0, []int{1}},
+			{func() int { return add("#a", "four") }, 4, []int{1, 4}},
+			{func() int { return add("#b", "other") }, 1, []int{1, 4}},
+		}
+
+		for i, step := range steps {
+			if got := step.do(); got != step.want {
+				t.Errorf("step %d: got quote #%d, want #%d", i+1, got,
+					step.want)
+			}
+			got := quoteNumbers(t, s, "#a")
+			if !reflect.DeepEqual(got, step.list) {
+				t.Errorf("step %d: quotes are %v, want %v", i+1, got,
+					step.list)
+			}
+		}
+
+		q, err := s.Quote("#a", 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Quote{Number: 4, Text: "four", Author: "bob", Added: added}
+		if q == nil || q.Number != want.Number || q.Text != want.Text ||
+			q.Author != want.Author || !q.Added.Equal(want.Added) {
+
+			t.Errorf("quote #4 is %+v, want %+v", q, want)
+		}
+
+		if q, err = s.Quote("#a", 2); err != nil || q != nil {
+			t.Errorf("deleted quote #2 is %+v, %v", q, err)
+		}
+	})
+}
+
+func TestStorageStrikes(t *testing.T) {
+	testBackends(t, func(t *testing.T, s Storage) {
+		last := time.Unix(1000000, 0)
+
+		count, when, err := s.Strikes("#a", "bob")
+		if err != nil || count != 0 || !when.IsZero() {
+			t.Errorf("new user has %d strikes at %v, %v", count, when, err)
+		}
+
+		tests := []struct {
+			count int
+			last  time.Time
+		}{
+			{1, last},
+			{3, last.Add(time.Minute)},
+			{0, time.Time{}},
+			{2, last.Add(time.Hour)},
+		}
+
+		for _, test := range tests {
+			err = s.SetStrikes("#a", "bob", test.count, test.last)
+			if err != nil {
+				t.Fatal(err)
+			}
+			count, when, err = s.Strikes("#a", "bob")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != test.count || (count != 0 && !when.Equal(test.last)) {
+				t.Errorf("set %d strikes at %v, got %d at %v", test.count,
+					test.last, count, when)
+			}
+		}
+
+		if count, _, _ = s.Strikes("#b", "bob"); count != 0 {
+			t.Errorf("strikes leaked to another channel: %d", count)
+		}
+
+		if decay, err := s.StrikeDecay("#a"); err != nil || decay != 0 {
+			t.Errorf("default strike decay is %v, %v, want 0", decay, err)
+		}
+		if err = s.SetStrikeDecay("#a", time.Hour*2); err != nil {
+			t.Fatal(err)
+		}
+		if decay, _ := s.StrikeDecay("#a"); decay != time.Hour*2 {
+			t.Errorf("strike decay is %v, want 2h", decay)
+		}
+	})
+}
+
+func TestStorageAuditLog(t *testing.T) {
+	testBackends(t, func(t *testing.T, s Storage) {
+		start := time.Unix(1000000, 0)
+		entries := []AuditEntry{
+			{start, "alice", "!command add", "!hi", "", "hello"},
+			{start.Add(time.Second), "bob", "!command edit", "!hi", "hello",
+				"hi there"},
+			{start.Add(time.Second * 2), "shigebot", "timeout", "spammer",
+				"", "links filter, strike 2"},
+			// same second as the previous entry
+			{start.Add(time.Second * 2), "alice", "!command remove", "!hi",
+				"hi there", ""},
+		}
+
+		for i := range entries {
+			if err := s.AddAuditEntry("#a", &entries[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			n    int
+			want []AuditEntry
+		}{
+			{0, []AuditEntry{entries[3], entries[2], entries[1], entries[0]}},
+			{-1, []AuditEntry{entries[3], entries[2], entries[1], entries[0]}},
+			{1, []AuditEntry{entries[3]}},
+			{2, []AuditEntry{entries[3], entries[2]}},
+			{10, []AuditEntry{entries[3], entries[2], entries[1], entries[0]}},
+		}
+
+		for _, test := range tests {
+			got, err := s.AuditLog("#a", test.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(test.want) {
+				t.Errorf("AuditLog(%d) returned %d entries, want %d", test.n,
+					len(got), len(test.want))
+				continue
+			}
+			for i, e := range got {
+				want := test.want[i]
+				if !e.Time.Equal(want.Time) {
+					t.Errorf("AuditLog(%d)[%d] time is %v, want %v", test.n,
+						i, e.Time, want.Time)
+				}
+				e.Time = want.Time
+				if *e != want {
+					t.Errorf("AuditLog(%d)[%d] = %+v, want %+v", test.n, i,
+						*e, want)
+				}
+			}
+		}
+
+		if got, _ := s.AuditLog("#b", 0); len(got) != 0 {
+			t.Errorf("audit log leaked to another channel: %v", got)
+		}
+	})
+}
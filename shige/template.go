@@ -0,0 +1,262 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Text command replies can contain variables in the form $(name args...),
+// which are replaced every time the command is used. $$ is a literal $.
+// Templates are parsed once and the values are substituted as plain text, so
+// a user can't sneak variables or twitch chat commands in through arguments.
+
+// templateContext holds everything a variable can refer to.
+type templateContext struct {
+	data *CommandData
+	// number of times the command has been used, including this time
+	count int
+}
+
+type templateFunc struct {
+	// minimum and maximum number of arguments
+	minArgs, maxArgs int
+	usage            string
+	// validates the arguments when parsing, can be nil
+	check func(args []string) error
+	eval  func(ctx *templateContext, args []string) string
+}
+
+var templateFuncs map[string]*templateFunc
+
+// parseRandomRange parses the arguments of $(random min max) and returns min
+// and how many values are in the range. Ranges too wide for rand.Int63n are
+// rejected.
+func parseRandomRange(args []string) (min, n int64, err error) {
+	min, err1 := strconv.ParseInt(args[0], 10, 64)
+	max, err2 := strconv.ParseInt(args[1], 10, 64)
+	// the unsigned difference can't overflow since min <= max
+	if err1 != nil || err2 != nil || min > max ||
+		uint64(max)-uint64(min) >= math.MaxInt64 {
+
+		err = fmt.Errorf("%s %s is not a valid range", args[0], args[1])
+		return
+	}
+	n = max - min + 1
+	return
+}
+
+func init() {
+	templateFuncs = map[string]*templateFunc{
+		"user": {0, 0, "$(user)", nil,
+			func(ctx *templateContext, args []string) string {
+				return ctx.data.User.DisplayName
+			}},
+
+		"touser": {0, 0, "$(touser)", nil,
+			func(ctx *templateContext, args []string) string {
+				if len(ctx.data.Args) == 0 {
+					return ctx.data.User.DisplayName
+				}
+				return strings.TrimPrefix(ctx.data.Args[0], "@")
+			}},
+
+		"args": {0, 0, "$(args)", nil,
+			func(ctx *templateContext, args []string) string {
+				return strings.Join(ctx.data.Args, " ")
+			}},
+
+		"arg": {1, 1, "$(arg n)",
+			func(args []string) error {
+				n, err := strconv.Atoi(args[0])
+				if err != nil || n < 1 {
+					return fmt.Errorf("%s is not a valid argument number",
+						args[0])
+				}
+				return nil
+			},
+			func(ctx *templateContext, args []string) string {
+				n, _ := strconv.Atoi(args[0])
+				if n > len(ctx.data.Args) {
+					return ""
+				}
+				return ctx.data.Args[n-1]
+			}},
+
+		"channel": {0, 0, "$(channel)", nil,
+			func(ctx *templateContext, args []string) string {
+				return ctx.data.Channel.name[1:]
+			}},
+
+		"count": {0, 0, "$(count)", nil,
+			func(ctx *templateContext, args []string) string {
+				return strconv.Itoa(ctx.count)
+			}},
+
+		"random": {2, 2, "$(random min max)",
+			func(args []string) error {
+				_, _, err := parseRandomRange(args)
+				return err
+			},
+			func(ctx *templateContext, args []string) string {
+				min, n, _ := parseRandomRange(args)
+				return strconv.FormatInt(min+rand.Int63n(n), 10)
+			}},
+
+		"uptime": {0, 0, "$(uptime)", nil,
+			func(ctx *templateContext, args []string) string {
+				uptime, online, err := ctx.data.Channel.Uptime()
+				switch {
+				case err != nil:
+					return "unknown"
+				case !online:
+					return "offline"
+				}
+				return uptime.String()
+			}},
+
+		"counter": {1, 1, "$(counter name)", nil,
+			func(ctx *templateContext, args []string) string {
+				value, _ := ctx.data.Channel.Counter(args[0])
+				return strconv.Itoa(value)
+			}},
+
+		"time": {1, 1, "$(time Area/City)",
+			func(args []string) error {
+				_, err := time.LoadLocation(args[0])
+				if err != nil {
+					return fmt.Errorf("unknown time zone %s", args[0])
+				}
+				return nil
+			},
+			func(ctx *templateContext, args []string) string {
+				loc, err := time.LoadLocation(args[0])
+				if err != nil {
+					return "unknown time zone"
+				}
+				return ctx.data.Channel.parent.now().In(loc).Format("15:04 MST")
+			}},
+	}
+}
+
+// a piece of a template: either literal text or a variable.
+type templateNode struct {
+	text string
+	fn   *templateFunc
+	args []string
+}
+
+type template []templateNode
+
+// parseTemplate parses text into a template, returning a descriptive error
+// if any of the variables is malformed.
+func parseTemplate(text string) (t template, err error) {
+	literal := ""
+	for i := 0; i < len(text); i++ {
+		if text[i] != '$' || i == len(text)-1 {
+			literal += text[i : i+1]
+			continue
+		}
+
+		switch text[i+1] {
+		case '$':
+			literal += "$"
+			i++
+			continue
+		case '(':
+			break
+		default:
+			literal += "$"
+			continue
+		}
+
+		end := strings.IndexByte(text[i:], ')')
+		if end < 0 {
+			err = fmt.Errorf("Unclosed $( at character %d.", i+1)
+			return
+		}
+
+		inner := text[i+2 : i+end]
+		if strings.Contains(inner, "$(") {
+			err = fmt.Errorf("Variables can't be nested (at character %d).",
+				i+1)
+			return
+		}
+
+		fields := strings.Fields(inner)
+		if len(fields) == 0 {
+			err = fmt.Errorf("Empty $() at character %d.", i+1)
+			return
+		}
+
+		name := strings.ToLower(fields[0])
+		args := fields[1:]
+		fn := templateFuncs[name]
+		if fn == nil {
+			err = fmt.Errorf("Unknown variable $(%s).", name)
+			return
+		}
+
+		if len(args) < fn.minArgs || len(args) > fn.maxArgs {
+			err = fmt.Errorf("Wrong number of arguments for $(%s), "+
+				"usage: %s.", name, fn.usage)
+			return
+		}
+
+		if fn.check != nil {
+			if err = fn.check(args); err != nil {
+				err = fmt.Errorf("Invalid $(%s): %v.", name, err)
+				return
+			}
+		}
+
+		if len(literal) != 0 {
+			t = append(t, templateNode{text: literal})
+			literal = ""
+		}
+		t = append(t, templateNode{fn: fn, args: args})
+		i += end
+	}
+
+	if len(literal) != 0 {
+		t = append(t, templateNode{text: literal})
+	}
+	return
+}
+
+// render evaluates every variable in the template.
+func (t template) render(ctx *templateContext) string {
+	res := ""
+	for _, node := range t {
+		if node.fn == nil {
+			res += node.text
+			continue
+		}
+
+		value := node.fn.eval(ctx, node.args)
+		if len(strings.TrimSpace(res)) == 0 {
+			// don't let a value turn the reply into a chat command
+			value = strings.TrimLeft(value, " /.")
+		}
+		res += value
+	}
+	return res
+}
@@ -0,0 +1,166 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tests := []struct {
+		text  string
+		nodes int
+		fail  bool
+	}{
+		{"hello", 1, false},
+		{"", 0, false},
+		{"costs $5 or $$10", 1, false},
+		{"ends with $", 1, false},
+		{"hi $(user)!", 3, false},
+		{"$(USER)", 1, false},
+		{"$(touser) $(arg 2) $(args)", 5, false},
+		{"$(random 1 6)", 1, false},
+		{"$(random -5 5)", 1, false},
+		{"$(time Europe/Rome)", 1, false},
+		{"$(user", 0, true},
+		{"$()", 0, true},
+		{"$( )", 0, true},
+		{"$(nope)", 0, true},
+		{"$(user extra)", 0, true},
+		{"$(arg)", 0, true},
+		{"$(arg 0)", 0, true},
+		{"$(arg x)", 0, true},
+		{"$(random 6 1)", 0, true},
+		{"$(random a b)", 0, true},
+		{"$(random 1)", 0, true},
+		{"$(time Nowhere/Atlantis)", 0, true},
+		{"$(args $(user))", 0, true},
+	}
+
+	for _, test := range tests {
+		tmpl, err := parseTemplate(test.text)
+		if test.fail {
+			if err == nil {
+				t.Errorf("parseTemplate(%q) succeeded, want an error",
+					test.text)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTemplate(%q): %v", test.text, err)
+			continue
+		}
+		if len(tmpl) != test.nodes {
+			t.Errorf("parseTemplate(%q) has %d nodes, want %d", test.text,
+				len(tmpl), test.nodes)
+		}
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	user := &UserInfo{Nick: "bob", DisplayName: "Bob"}
+	tests := []struct {
+		text  string
+		args  []string
+		count int
+		want  string
+	}{
+		{"hello", nil, 0, "hello"},
+		{"costs $$5", nil, 0, "costs $5"},
+		{"hi $(user)!", nil, 0, "hi Bob!"},
+		{"$(touser) is here", nil, 0, "Bob is here"},
+		{"$(touser) is here", []string{"@alice"}, 0, "alice is here"},
+		{"[$(arg 2)]", []string{"a"}, 0, "[]"},
+		{"[$(arg 2)]", []string{"a", "b"}, 0, "[b]"},
+		{"used $(count) times", nil, 7, "used 7 times"},
+		{"$(args)", []string{"$(user)"}, 0, "$(user)"},
+		// arguments can't turn the reply into a chat command
+		{"$(args)", []string{"/ban", "alice"}, 0, "ban alice"},
+		{" $(arg 1)", []string{".timeout"}, 0, " timeout"},
+		{"say $(args)", []string{"/ban", "alice"}, 0, "say /ban alice"},
+	}
+
+	for _, test := range tests {
+		tmpl, err := parseTemplate(test.text)
+		if err != nil {
+			t.Errorf("parseTemplate(%q): %v", test.text, err)
+			continue
+		}
+		ctx := &templateContext{
+			data:  &CommandData{Args: test.args, Nick: user.Nick, User: user},
+			count: test.count,
+		}
+		if got := tmpl.render(ctx); got != test.want {
+			t.Errorf("render(%q) with %q = %q, want %q", test.text,
+				test.args, got, test.want)
+		}
+	}
+}
+
+func TestParseRandomRange(t *testing.T) {
+	tests := []struct {
+		min, max string
+		wantMin  int64
+		wantN    int64
+		fail     bool
+	}{
+		{"1", "6", 1, 6, false},
+		{"5", "5", 5, 1, false},
+		{"-10", "10", -10, 21, false},
+		{"0", "9223372036854775806", 0, 9223372036854775807, false},
+		{"-1", "9223372036854775806", 0, 0, true},
+		{"0", "9223372036854775807", 0, 0, true},
+		{"-9223372036854775808", "9223372036854775807", 0, 0, true},
+		{"6", "1", 0, 0, true},
+		{"1", "99999999999999999999", 0, 0, true},
+		{"1.5", "2", 0, 0, true},
+	}
+
+	for _, test := range tests {
+		min, n, err := parseRandomRange([]string{test.min, test.max})
+		if test.fail {
+			if err == nil {
+				t.Errorf("parseRandomRange(%s, %s) succeeded, want an error",
+					test.min, test.max)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRandomRange(%s, %s): %v", test.min, test.max, err)
+			continue
+		}
+		if min != test.wantMin || n != test.wantN {
+			t.Errorf("parseRandomRange(%s, %s) = %d, %d, want %d, %d",
+				test.min, test.max, min, n, test.wantMin, test.wantN)
+		}
+	}
+}
+
+func TestRandomStaysInRange(t *testing.T) {
+	tmpl, err := parseTemplate("$(random -2 2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &templateContext{data: &CommandData{}}
+	for i := 0; i < 200; i++ {
+		s := tmpl.render(ctx)
+		if n, err := strconv.Atoi(s); err != nil || n < -2 || n > 2 {
+			t.Fatalf("$(random -2 2) rendered %q", s)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// how often the bot checks whether any timer should fire
+const timerResolution = time.Second * 10
+
+// A Timer is a message that is periodically sent to a channel, as long as
+// people are chatting.
+type Timer struct {
+	// Text is the message that will be sent.
+	Text string
+	// Interval is the minimum time between two posts.
+	Interval time.Duration
+	// MinLines is the number of chat lines that must have been sent since
+	// the last post before the timer fires again.
+	MinLines int
+	// Enabled is false when the timer is paused.
+	Enabled bool
+
+	lastPost    time.Time
+	linesAtPost int
+}
+
+func (t Timer) String() string {
+	state := ""
+	if !t.Enabled {
+		state = ", disabled"
+	}
+	return fmt.Sprintf("every %v after %d lines%s: %s", t.Interval,
+		t.MinLines, state, t.Text)
+}
+
+// countLine keeps track of chat activity for the timers.
+func (c *Channel) countLine() {
+	c.parent.w.Do(func() { c.lines++ })
+}
+
+// Timers returns a copy of every timer in the channel by name.
+func (c *Channel) Timers() map[string]Timer {
+	resp := make(chan map[string]Timer, 1)
+	c.parent.w.Do(func() {
+		res := make(map[string]Timer)
+		for name, t := range c.timers {
+			res[name] = *t
+		}
+		resp <- res
+		close(resp)
+	})
+	return <-resp
+}
+
+// Timer returns a copy of a timer. ok is false if it doesn't exist.
+func (c *Channel) Timer(name string) (t Timer, ok bool) {
+	t, ok = c.Timers()[name]
+	return
+}
+
+// SetTimer adds or replaces a timer. The timer will first fire after
+// interval.
+func (c *Channel) SetTimer(name string, t Timer) error {
+	if t.Interval < time.Minute {
+		return fmt.Errorf("Timers can't be shorter than a minute.")
+	}
+
+	if t.MinLines < 0 {
+		t.MinLines = 0
+	}
+
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.SetTimer(c.name, name, &t)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.parent.w.Await(func() {
+		t.lastPost = c.parent.now()
+		t.linesAtPost = c.lines
+		c.timers[name] = &t
+	})
+	c.Println("Set timer", name, "every", t.Interval, "and", t.MinLines,
+		"lines ->", t.Text)
+	return nil
+}
+
+// RemoveTimer removes a timer.
+func (c *Channel) RemoveTimer(name string) error {
+	if _, ok := c.Timer(name); !ok {
+		return fmt.Errorf("Timer %s doesn't exist.", name)
+	}
+
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.RemoveTimer(c.name, name)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.parent.w.Await(func() { delete(c.timers, name) })
+	c.Println("Removed timer", name)
+	return nil
+}
+
+// EnableTimer pauses or resumes a timer.
+func (c *Channel) EnableTimer(name string, enabled bool) error {
+	t, ok := c.Timer(name)
+	if !ok {
+		return fmt.Errorf("Timer %s doesn't exist.", name)
+	}
+
+	t.Enabled = enabled
+	return c.SetTimer(name, t)
+}
+
+// TimerList returns a comma-separated, alphabetically sorted list of the
+// timers, marking disabled ones.
+func (c *Channel) TimerList() string {
+	timers := c.Timers()
+	names := make([]string, 0, len(timers))
+	for name, t := range timers {
+		if !t.Enabled {
+			name += " (disabled)"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := ""
+	for i, name := range names {
+		if i != 0 {
+			res += ", "
+		}
+		res += name
+	}
+	return res
+}
+
+// sends every timer that is due.
+func (c *Channel) checkTimers() {
+	due := make([]string, 0)
+	c.parent.w.Await(func() {
+		for _, t := range c.timers {
+			if !t.Enabled || c.parent.since(t.lastPost) < t.Interval ||
+				c.lines-t.linesAtPost < t.MinLines {
+
+				continue
+			}
+
+			t.lastPost = c.parent.now()
+			t.linesAtPost = c.lines
+			due = append(due, t.Text)
+		}
+	})
+
+	for _, text := range due {
+		c.Privmsgf("%s", text)
+	}
+}
+
+// runTimers checks the timers of every channel until stop is closed.
+func (b *Bot) runTimers(stop chan bool) {
+	ticker := time.NewTicker(timerResolution)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			channels := make([]*Channel, 0)
+			b.w.Await(func() {
+				for _, c := range b.channels {
+					channels = append(channels, c)
+				}
+			})
+
+			if !b.pending.add() {
+				continue
+			}
+			for _, c := range channels {
+				c.checkTimers()
+			}
+			b.pending.done()
+
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,188 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Trigger replies with a text command whenever a chat message contains a
+// keyword or matches a regular expression, without needing a ! prefix.
+type Trigger struct {
+	// Pattern is the keyword or regular expression to look for.
+	Pattern string
+	// Regex is true when Pattern is a go regular expression. Otherwise
+	// Pattern is matched as a case insensitive keyword anywhere in the
+	// message.
+	Regex bool
+	// Command is the name of the text command the trigger replies with.
+	Command string
+	// Cooldown is the minimum time between two replies.
+	Cooldown time.Duration
+	// Perm is the minimum role a user needs to fire the trigger. Users also
+	// need the permission of the command it replies with.
+	Perm Permission
+
+	re        *regexp.Regexp
+	lastUsage time.Time
+}
+
+// compiles the trigger's pattern.
+func (t *Trigger) compile() (err error) {
+	if t.Regex {
+		t.re, err = regexp.Compile(t.Pattern)
+		return
+	}
+	t.re, err = regexp.Compile("(?i)" + regexp.QuoteMeta(t.Pattern))
+	return
+}
+
+// Matches returns whether msg fires the trigger.
+func (t *Trigger) Matches(msg string) bool {
+	return t.re != nil && t.re.MatchString(msg)
+}
+
+// Triggers returns a copy of every trigger in the channel by name.
+func (c *Channel) Triggers() map[string]Trigger {
+	resp := make(chan map[string]Trigger, 1)
+	c.parent.w.Do(func() {
+		res := make(map[string]Trigger)
+		for name, t := range c.triggers {
+			res[name] = *t
+		}
+		resp <- res
+		close(resp)
+	})
+	return <-resp
+}
+
+// Trigger returns a copy of a trigger. ok is false if it doesn't exist.
+func (c *Channel) Trigger(name string) (t Trigger, ok bool) {
+	t, ok = c.Triggers()[name]
+	return
+}
+
+// SetTrigger adds or replaces a trigger. Returns an error if the pattern is
+// not a valid regular expression.
+func (c *Channel) SetTrigger(name string, t Trigger) error {
+	if len(strings.TrimSpace(t.Pattern)) == 0 {
+		return fmt.Errorf("Trigger patterns can't be empty.")
+	}
+
+	if err := t.compile(); err != nil {
+		return fmt.Errorf("Invalid regular expression: %v", err)
+	}
+
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.SetTrigger(c.name, name, &t)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.parent.w.Await(func() { c.triggers[name] = &t })
+	c.Println("Set trigger", name, t.Pattern, "->", t.Command)
+	return nil
+}
+
+// RemoveTrigger removes a trigger.
+func (c *Channel) RemoveTrigger(name string) error {
+	if _, ok := c.Trigger(name); !ok {
+		return fmt.Errorf("Trigger %s doesn't exist.", name)
+	}
+
+	err := c.parent.attemptQuery(func() error {
+		return c.parent.db.RemoveTrigger(c.name, name)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.parent.w.Await(func() { delete(c.triggers, name) })
+	c.Println("Removed trigger", name)
+	return nil
+}
+
+// TriggerList returns a comma-separated, alphabetically sorted list of the
+// triggers and the commands they reply with.
+func (c *Channel) TriggerList() string {
+	triggers := c.Triggers()
+	lines := make([]string, 0, len(triggers))
+	for name, t := range triggers {
+		lines = append(lines, fmt.Sprintf("%s (%s -> !%s)", name, t.Pattern,
+			t.Command))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, ", ")
+}
+
+// checkTriggers replies to msg with the first trigger, by name, that it
+// fires. Returns true if a trigger fired.
+func (c *Channel) checkTriggers(msg string, data *CommandData) bool {
+	triggers := c.Triggers()
+	names := make([]string, 0, len(triggers))
+	for name := range triggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t := triggers[name]
+		if !t.Matches(msg) || !c.HasPermission(data.User, t.Perm) {
+			continue
+		}
+
+		command := c.lookupCommand(t.Command)
+		if command == nil {
+			c.Println("Trigger", name, "refers to missing command", t.Command)
+			continue
+		}
+
+		// triggers can't be used to get around the command's own permission
+		perm := command.Permission()
+		if !c.HasPermission(data.User, perm) {
+			c.Println("Trigger", name, "not fired because the user is not",
+				perm)
+			continue
+		}
+
+		resp := make(chan bool, 1)
+		c.parent.w.Do(func() {
+			live := c.triggers[name]
+			onCooldown := live == nil ||
+				c.parent.since(live.lastUsage) < live.Cooldown
+			if !onCooldown {
+				live.lastUsage = c.parent.now()
+			}
+			resp <- onCooldown
+			close(resp)
+		})
+		if <-resp {
+			c.Println("Trigger", name, "is still on cooldown")
+			continue
+		}
+
+		c.Println("Trigger", name, "fired, replying with", t.Command)
+		command.Run(data)
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,143 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of Shigebot.
+	Shigebot is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	Shigebot is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with Shigebot. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package shige
+
+import (
+	"github.com/thoj/go-ircevent"
+	"strings"
+)
+
+// UserInfo holds the information twitch sends in the IRCv3 tags of a chat
+// message about the user that sent it.
+type UserInfo struct {
+	// Nick is the irc nickname (login name) of the user.
+	Nick string
+	// DisplayName is the name as the user chose to capitalize it. Falls back
+	// to Nick if twitch didn't send one.
+	DisplayName string
+	// UserID is the numeric twitch user id.
+	UserID string
+	// RoomID is the numeric twitch user id of the channel the message was
+	// sent to.
+	RoomID string
+	// Color is the user's name color in #RRGGBB format, empty if not set.
+	Color string
+	// Badges maps each badge name (moderator, subscriber, vip, ...) to its
+	// version.
+	Badges map[string]string
+	// Broadcaster is true when the user owns the channel.
+	Broadcaster bool
+	// Mod is true when the user is a moderator in the channel.
+	Mod bool
+	// VIP is true when the user is a VIP in the channel.
+	VIP bool
+	// Subscriber is true when the user is subscribed to the channel.
+	Subscriber bool
+}
+
+// IsMod returns whether the user is allowed to use mod commands. The
+// broadcaster is always considered a mod.
+func (u *UserInfo) IsMod() bool {
+	return u != nil && (u.Mod || u.Broadcaster)
+}
+
+// HasBadge returns whether the user is displaying the badge called name.
+func (u *UserInfo) HasBadge(name string) bool {
+	if u == nil {
+		return false
+	}
+	_, ok := u.Badges[name]
+	return ok
+}
+
+// unescapes a tag value as described in the IRCv3 message-tags spec.
+func unescapeTagValue(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+
+	res := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			res = append(res, value[i])
+			continue
+		}
+
+		i++
+		switch value[i] {
+		case ':':
+			res = append(res, ';')
+		case 's':
+			res = append(res, ' ')
+		case 'r':
+			res = append(res, '\r')
+		case 'n':
+			res = append(res, '\n')
+		default:
+			res = append(res, value[i])
+		}
+	}
+	return string(res)
+}
+
+// parses a twitch badge list such as "moderator/1,subscriber/12".
+func parseBadges(value string) map[string]string {
+	badges := make(map[string]string)
+	for _, badge := range strings.Split(value, ",") {
+		if len(badge) == 0 {
+			continue
+		}
+		split := strings.SplitN(badge, "/", 2)
+		version := ""
+		if len(split) == 2 {
+			version = split[1]
+		}
+		badges[split[0]] = version
+	}
+	return badges
+}
+
+// builds a UserInfo from the tags of a PRIVMSG event sent to channel.
+// Works even when the server sent no tags, in which case only the nickname
+// and the broadcaster flag will be set.
+func parseUserInfo(channel string, event *irc.Event) *UserInfo {
+	tags := make(map[string]string)
+	for key, value := range event.Tags {
+		tags[key] = unescapeTagValue(value)
+	}
+
+	u := &UserInfo{
+		Nick:        event.Nick,
+		DisplayName: tags["display-name"],
+		UserID:      tags["user-id"],
+		RoomID:      tags["room-id"],
+		Color:       tags["color"],
+		Badges:      parseBadges(tags["badges"]),
+	}
+
+	if len(u.DisplayName) == 0 {
+		u.DisplayName = u.Nick
+	}
+
+	u.Broadcaster = u.HasBadge("broadcaster") ||
+		(len(channel) > 1 && strings.EqualFold(channel[1:], u.Nick))
+	u.Mod = tags["mod"] == "1" || u.HasBadge("moderator")
+	u.VIP = len(tags["vip"]) != 0 || u.HasBadge("vip")
+	u.Subscriber = tags["subscriber"] == "1" || u.HasBadge("subscriber") ||
+		u.HasBadge("founder")
+
+	return u
+}
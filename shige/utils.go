@@ -16,31 +16,62 @@
 package shige
 
 import (
+	"database/sql/driver"
 	"errors"
-	"fmt"
-	"math/rand"
+	"strings"
+	"time"
 )
 
-func (b Bot) randStr() string {
-	if b.isMod {
-		return ""
+const (
+	// how many times a query that failed with a transient error is retried
+	queryRetries = 4
+	// how long to wait before the first retry, doubled on every attempt
+	queryRetryDelay = 50 * time.Millisecond
+)
+
+// ErrDatabase is returned to chat commands when a query fails. The actual
+// error is logged.
+var ErrDatabase = errors.New("Database error. Please try again.")
+
+// isTransient checks whether a failed query might succeed if retried, such
+// as when the database is locked by another query or the connection dropped.
+func isTransient(err error) bool {
+	if err == driver.ErrBadConn {
+		return true
+	}
+
+	if t, ok := err.(interface {
+		Temporary() bool
+	}); ok && t.Temporary() {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"locked", "busy", "timeout"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
 	}
-	return fmt.Sprintf("[%d]", rand.Int31n(99))
+
+	return false
 }
 
-func attemptQuery(q func() error) error {
-	var err error
-	for i := 0; i < 5; i++ {
-		// make 5 attempts just in case concurrent queries made the query fail
+// attemptQuery runs q, retrying it with a backoff as long as it fails with a
+// transient error. Errors are logged and replaced by ErrDatabase.
+func (b Bot) attemptQuery(q func() error) error {
+	delay := queryRetryDelay
+	err := q()
+	for i := 0; err != nil && isTransient(err) && i < queryRetries; i++ {
+		b.log.Println("DB: Retrying after transient error:", err)
+		time.Sleep(delay)
+		delay *= 2
 		err = q()
-		if err != nil {
-			fmt.Println(err)
-			continue
-		}
-		break
 	}
+
 	if err != nil {
-		return errors.New("Database error. Please try again.")
+		b.log.Println("DB:", err)
+		return ErrDatabase
 	}
+
 	return nil
 }
@@ -69,7 +69,9 @@ func (w *Worker) work() {
 	for running {
 		select {
 		case job, running = <-w.jobs:
-			job()
+			if running {
+				job()
+			}
 		case <-w.kill:
 			running = false
 			log.Println("Worker", w.name, "received kill signal.")